@@ -0,0 +1,127 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*BaggageRestrictions limits the baggage items injected in and extracted from the carriers, a zero value means no limit. The items are checked in the order of their keys and the rejected ones are logged and left out*/
+type BaggageRestrictions struct {
+	// AllowedKeys lists the only keys propagated, compared ignoring the case, any key is allowed if it is empty
+	AllowedKeys []string
+	// MaxKeyLength is the number of bytes of a key
+	MaxKeyLength int
+	// MaxValueLength is the number of bytes of a value
+	MaxValueLength int
+	// MaxItems is the number of items
+	MaxItems int
+	// MaxTotalBytes is the total size of the keys and values
+	MaxTotalBytes int
+}
+
+// restrict returns the baggage items that pass the restrictions and the reasons of the rejected ones by key.
+// Keys that are not valid http header field name tokens are always rejected as they end up in header names
+func (r *BaggageRestrictions) restrict(baggage map[string]string) (map[string]string, map[string]string) {
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	allowed := make(map[string]string, len(baggage))
+	rejected := make(map[string]string)
+	total := 0
+	for _, k := range keys {
+		v := baggage[k]
+		switch {
+		case !isHeaderToken(k):
+			rejected[k] = "key is not a valid header token"
+		case !r.isAllowed(k):
+			rejected[k] = "key is not allowed"
+		case r.MaxKeyLength > 0 && len(k) > r.MaxKeyLength:
+			rejected[k] = "key is too long"
+		case r.MaxValueLength > 0 && len(v) > r.MaxValueLength:
+			rejected[k] = "value is too long"
+		case r.MaxItems > 0 && len(allowed) >= r.MaxItems:
+			rejected[k] = "too many items"
+		case r.MaxTotalBytes > 0 && total+len(k)+len(v) > r.MaxTotalBytes:
+			rejected[k] = "total size exceeded"
+		default:
+			allowed[k] = v
+			total += len(k) + len(v)
+		}
+	}
+	return allowed, rejected
+}
+
+func (r *BaggageRestrictions) isAllowed(key string) bool {
+	if len(r.AllowedKeys) == 0 {
+		return true
+	}
+	for _, allowedKey := range r.AllowedKeys {
+		if strings.EqualFold(allowedKey, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHeaderToken tells if the key is a non empty token as defined by RFC 7230
+func isHeaderToken(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// restrictBaggage applies the baggage restrictions of the tracer to the context and logs the rejected items
+// on a single debug line, as they come from the carriers and could otherwise flood the logs
+func (tracer *Tracer) restrictBaggage(ctx *SpanContext, direction string) *SpanContext {
+	if tracer.baggageRestrictions == nil || len(ctx.Baggage) == 0 {
+		return ctx
+	}
+	allowed, rejected := tracer.baggageRestrictions.restrict(ctx.Baggage)
+	if len(rejected) == 0 {
+		return ctx
+	}
+	keys := make([]string, 0, len(rejected))
+	for k := range rejected {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	reasons := make([]string, 0, len(keys))
+	for _, k := range keys {
+		reasons = append(reasons, strconv.Quote(k)+": "+rejected[k])
+	}
+	tracer.logger.Debug("%d baggage items rejected on %s, %s", len(keys), direction, strings.Join(reasons, ", "))
+	restricted := *ctx
+	restricted.Baggage = allowed
+	return &restricted
+}
@@ -0,0 +1,98 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type BaggageRestrictionsTestSuite struct {
+	suite.Suite
+	logs   bytes.Buffer
+	tracer opentracing.Tracer
+}
+
+func (suite *BaggageRestrictionsTestSuite) SetupTest() {
+	suite.logs.Reset()
+	suite.tracer, _ = NewTracer("my-service", NewInMemoryDispatcher(),
+		TracerOptionsFactory.Logger(NewStdLogger(log.New(&suite.logs, "", 0))),
+		TracerOptionsFactory.BaggageRestrictions(BaggageRestrictions{
+			AllowedKeys:    []string{"tenant", "user", "locale", "session"},
+			MaxKeyLength:   6,
+			MaxValueLength: 8,
+			MaxItems:       2,
+			MaxTotalBytes:  20,
+		}))
+}
+
+func (suite *BaggageRestrictionsTestSuite) TearDownTest() {
+	suite.NoError(suite.tracer.(*Tracer).Close())
+}
+
+func (suite *BaggageRestrictionsTestSuite) TestInjectLeavesOutRejectedItems() {
+	span := suite.tracer.StartSpan("op")
+	span.SetBaggageItem("user", "alice")
+	span.SetBaggageItem("secret", "s3cr3t")
+	span.SetBaggageItem("locale", "en_US.UTF-8")
+	span.SetBaggageItem("session", "abc")
+
+	carrier := opentracing.TextMapCarrier{}
+	suite.Require().NoError(suite.tracer.Inject(span.Context(), opentracing.TextMap, carrier))
+	suite.Equal("alice", carrier["Baggage-user"])
+	suite.Len(carrier, 4)
+
+	// the span keeps its baggage, only the propagated items are restricted
+	suite.Equal("s3cr3t", span.BaggageItem("secret"))
+	suite.Equal(`DEBUG 3 baggage items rejected on inject, "locale": value is too long, "secret": key is not allowed, "session": key is too long`+"\n",
+		suite.logs.String(), "the rejected items should be logged on a single line")
+}
+
+func (suite *BaggageRestrictionsTestSuite) TestExtractLeavesOutRejectedItems() {
+	carrier := opentracing.HTTPHeadersCarrier{
+		"Trace-Id":        []string{"t1"},
+		"Span-Id":         []string{"s1"},
+		"Baggage-Tenant":  []string{"acme"},
+		"Baggage-User":    []string{"alice"},
+		"Baggage-Locale":  []string{"en"},
+		"Baggage-Payload": []string{"x"},
+	}
+	ctx, err := suite.tracer.Extract(opentracing.HTTPHeaders, carrier)
+	suite.Require().NoError(err)
+
+	spanContext := ctx.(*SpanContext)
+	suite.Equal("t1", spanContext.TraceID)
+	suite.True(spanContext.IsExtractedContext)
+	suite.Equal(map[string]string{"locale": "en", "tenant": "acme"}, spanContext.Baggage)
+	suite.Equal(`DEBUG 2 baggage items rejected on extract, "payload": key is not allowed, "user": too many items`+"\n", suite.logs.String())
+}
+
+func (suite *BaggageRestrictionsTestSuite) TestTotalSizeAndHeaderTokens() {
+	restrictions := BaggageRestrictions{MaxTotalBytes: 11}
+	allowed, rejected := restrictions.restrict(map[string]string{"a": "12345", "b": "12345", "bad key": "v"})
+	suite.Equal(map[string]string{"a": "12345"}, allowed)
+	suite.Equal(map[string]string{"b": "total size exceeded", "bad key": "key is not a valid header token"}, rejected)
+}
+
+func TestUnitBaggageRestrictionsSuite(t *testing.T) {
+	suite.Run(t, new(BaggageRestrictionsTestSuite))
+}
@@ -0,0 +1,38 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"time"
+)
+
+/*Clock gives the current time to the tracer*/
+type Clock interface {
+	Now() time.Time
+}
+
+/*SystemClock gives the current time of the system, it is the clock of the tracer by default*/
+var SystemClock Clock = ClockFunc(time.Now)
+
+/*ClockFunc adapts a function to Clock*/
+type ClockFunc func() time.Time
+
+/*Now returns the time given by the function*/
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
@@ -0,0 +1,469 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// DispatcherTypeAgent sends the spans to haystack-agent over grpc, the endpoint is host:port
+	DispatcherTypeAgent = "agent"
+
+	// DispatcherTypeHTTP posts the spans to a haystack http collector, the endpoint is the url
+	DispatcherTypeHTTP = "http"
+
+	// DispatcherTypeFile writes the spans as json lines, the endpoint is the file path
+	DispatcherTypeFile = "file"
+
+	// CodexDefault propagates the baggage values as is
+	CodexDefault = "default"
+
+	// CodexURL propagates the baggage values url encoded
+	CodexURL = "url"
+
+	// CodexBase64 propagates the baggage values base64 encoded
+	CodexBase64 = "base64"
+)
+
+/*Duration is a time.Duration written as a string like "3s" or "500ms" in the config files and environment*/
+type Duration time.Duration
+
+/*UnmarshalText parses the duration with time.ParseDuration*/
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+/*MarshalText formats the duration with time.Duration.String*/
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+/*Config holds the settings of a tracer and its dispatcher, as loaded from a file or the environment*/
+type Config struct {
+	// ServiceName is the name of the service recorded in every span, HAYSTACK_SERVICE_NAME
+	ServiceName string           `json:"serviceName" yaml:"serviceName"`
+	Dispatcher  DispatcherConfig `json:"dispatcher" yaml:"dispatcher"`
+	// Tags are added to every span, HAYSTACK_TAGS as comma separated key=value pairs
+	Tags map[string]string `json:"tags" yaml:"tags"`
+	// SamplingRate is the fraction of the traces dispatched, 1 if unset, HAYSTACK_SAMPLING_RATE
+	SamplingRate *float64          `json:"samplingRate" yaml:"samplingRate"`
+	Propagation  PropagationConfig `json:"propagation" yaml:"propagation"`
+	// DualSpanMode gives the client and server spans their own span ids, HAYSTACK_DUAL_SPAN_MODE
+	DualSpanMode bool `json:"dualSpanMode" yaml:"dualSpanMode"`
+}
+
+/*DispatcherConfig holds the settings of the dispatcher*/
+type DispatcherConfig struct {
+	// Type is one of agent, http or file, agent if unset, HAYSTACK_DISPATCHER_TYPE
+	Type string `json:"type" yaml:"type"`
+	// Endpoint is host:port for agent, the url for http and the path for file, HAYSTACK_DISPATCHER_ENDPOINT
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// Timeout of a send, 3s if unset, HAYSTACK_DISPATCHER_TIMEOUT
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+	// QueueLength is the number of spans waiting to be sent, 1000 if unset, HAYSTACK_DISPATCHER_QUEUE_LENGTH
+	QueueLength int `json:"queueLength" yaml:"queueLength"`
+	// DropWhenFull drops the spans instead of blocking the application when the queue is full, HAYSTACK_DISPATCHER_DROP_WHEN_FULL
+	DropWhenFull bool `json:"dropWhenFull" yaml:"dropWhenFull"`
+	// Headers are added to the http requests, HAYSTACK_DISPATCHER_HEADERS as comma separated key=value pairs
+	Headers map[string]string `json:"headers" yaml:"headers"`
+	// TLS connects to the agent or the collector over tls if set, HAYSTACK_DISPATCHER_TLS, or else any HAYSTACK_DISPATCHER_TLS_* variable, enables it
+	TLS *TLSOptions `json:"tls" yaml:"tls"`
+}
+
+/*PropagationConfig holds the settings of the TextMap and HTTPHeaders propagators*/
+type PropagationConfig struct {
+	// TraceIDKey defaults to Trace-ID, HAYSTACK_PROPAGATION_TRACE_ID_KEY
+	TraceIDKey string `json:"traceIdKey" yaml:"traceIdKey"`
+	// SpanIDKey defaults to Span-ID, HAYSTACK_PROPAGATION_SPAN_ID_KEY
+	SpanIDKey string `json:"spanIdKey" yaml:"spanIdKey"`
+	// ParentSpanIDKey defaults to Parent-ID, HAYSTACK_PROPAGATION_PARENT_SPAN_ID_KEY
+	ParentSpanIDKey string `json:"parentSpanIdKey" yaml:"parentSpanIdKey"`
+	// BaggagePrefix defaults to Baggage-, HAYSTACK_PROPAGATION_BAGGAGE_PREFIX
+	BaggagePrefix string `json:"baggagePrefix" yaml:"baggagePrefix"`
+	// TextMapCodex is default, url or base64, default if unset, HAYSTACK_PROPAGATION_TEXT_MAP_CODEX
+	TextMapCodex string `json:"textMapCodex" yaml:"textMapCodex"`
+	// HTTPHeadersCodex is default, url or base64, url if unset, HAYSTACK_PROPAGATION_HTTP_HEADERS_CODEX
+	HTTPHeadersCodex string `json:"httpHeadersCodex" yaml:"httpHeadersCodex"`
+	// PreserveBaggageKeyCase keeps the case of the baggage keys extracted from TextMap carriers, HAYSTACK_PROPAGATION_PRESERVE_BAGGAGE_KEY_CASE
+	PreserveBaggageKeyCase bool `json:"preserveBaggageKeyCase" yaml:"preserveBaggageKeyCase"`
+}
+
+/*ConfigError lists every invalid setting of a config*/
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid haystack config: " + strings.Join(e.Problems, "; ")
+}
+
+/*LoadConfigFile reads the config from a yaml (.yaml, .yml) or json (.json) file*/
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, config)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	default:
+		return nil, fmt.Errorf("unknown config file extension %q, expecting .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse the config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+/*ConfigFromEnv reads the config from the HAYSTACK_* environment variables*/
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{}
+	if err := config.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+/*ApplyEnv overrides the settings with the HAYSTACK_* environment variables that are set, e.g. on top of a config file*/
+func (c *Config) ApplyEnv() error {
+	var problems []string
+	env := func(name string) (string, bool) {
+		return os.LookupEnv("HAYSTACK_" + name)
+	}
+	setString := func(name string, target *string) {
+		if value, ok := env(name); ok {
+			*target = value
+		}
+	}
+	setBool := func(name string, target *bool) {
+		if value, ok := env(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("HAYSTACK_%s: %q is not a boolean", name, value))
+				return
+			}
+			*target = b
+		}
+	}
+	setPairs := func(name string, target *map[string]string) {
+		if value, ok := env(name); ok {
+			pairs, err := parsePairs(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("HAYSTACK_%s: %v", name, err))
+				return
+			}
+			*target = pairs
+		}
+	}
+
+	setString("SERVICE_NAME", &c.ServiceName)
+	setString("DISPATCHER_TYPE", &c.Dispatcher.Type)
+	setString("DISPATCHER_ENDPOINT", &c.Dispatcher.Endpoint)
+	if value, ok := env("DISPATCHER_TIMEOUT"); ok {
+		if err := c.Dispatcher.Timeout.UnmarshalText([]byte(value)); err != nil {
+			problems = append(problems, fmt.Sprintf("HAYSTACK_DISPATCHER_TIMEOUT: %v", err))
+		}
+	}
+	if value, ok := env("DISPATCHER_QUEUE_LENGTH"); ok {
+		queueLength, err := strconv.Atoi(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("HAYSTACK_DISPATCHER_QUEUE_LENGTH: %q is not an integer", value))
+		} else {
+			c.Dispatcher.QueueLength = queueLength
+		}
+	}
+	setBool("DISPATCHER_DROP_WHEN_FULL", &c.Dispatcher.DropWhenFull)
+	setPairs("DISPATCHER_HEADERS", &c.Dispatcher.Headers)
+	c.applyTLSEnv(env, setString, setBool)
+	setPairs("TAGS", &c.Tags)
+	if value, ok := env("SAMPLING_RATE"); ok {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("HAYSTACK_SAMPLING_RATE: %q is not a number", value))
+		} else {
+			c.SamplingRate = &rate
+		}
+	}
+	setString("PROPAGATION_TRACE_ID_KEY", &c.Propagation.TraceIDKey)
+	setString("PROPAGATION_SPAN_ID_KEY", &c.Propagation.SpanIDKey)
+	setString("PROPAGATION_PARENT_SPAN_ID_KEY", &c.Propagation.ParentSpanIDKey)
+	setString("PROPAGATION_BAGGAGE_PREFIX", &c.Propagation.BaggagePrefix)
+	setString("PROPAGATION_TEXT_MAP_CODEX", &c.Propagation.TextMapCodex)
+	setString("PROPAGATION_HTTP_HEADERS_CODEX", &c.Propagation.HTTPHeadersCodex)
+	setBool("PROPAGATION_PRESERVE_BAGGAGE_KEY_CASE", &c.Propagation.PreserveBaggageKeyCase)
+	setBool("DUAL_SPAN_MODE", &c.DualSpanMode)
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *Config) applyTLSEnv(env func(string) (string, bool), setString func(string, *string), setBool func(string, *bool)) {
+	enabled := c.Dispatcher.TLS != nil
+	if _, ok := env("DISPATCHER_TLS"); ok {
+		setBool("DISPATCHER_TLS", &enabled)
+	} else {
+		for _, name := range []string{"CA_FILE", "CERT_FILE", "KEY_FILE", "SERVER_NAME", "RELOAD"} {
+			if _, ok := env("DISPATCHER_TLS_" + name); ok {
+				enabled = true
+			}
+		}
+	}
+	if !enabled {
+		c.Dispatcher.TLS = nil
+		return
+	}
+	if c.Dispatcher.TLS == nil {
+		c.Dispatcher.TLS = &TLSOptions{}
+	}
+	setString("DISPATCHER_TLS_CA_FILE", &c.Dispatcher.TLS.CAFile)
+	setString("DISPATCHER_TLS_CERT_FILE", &c.Dispatcher.TLS.CertFile)
+	setString("DISPATCHER_TLS_KEY_FILE", &c.Dispatcher.TLS.KeyFile)
+	setString("DISPATCHER_TLS_SERVER_NAME", &c.Dispatcher.TLS.ServerName)
+	setBool("DISPATCHER_TLS_RELOAD", &c.Dispatcher.TLS.Reload)
+}
+
+func parsePairs(value string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return pairs, nil
+}
+
+/*WithDefaults returns a copy of the config where the unset settings take their default value*/
+func (c Config) WithDefaults() Config {
+	if c.Dispatcher.Type == "" {
+		c.Dispatcher.Type = DispatcherTypeAgent
+	}
+	if c.Dispatcher.Endpoint == "" {
+		switch c.Dispatcher.Type {
+		case DispatcherTypeAgent:
+			c.Dispatcher.Endpoint = "haystack-agent:35000"
+		case DispatcherTypeHTTP:
+			c.Dispatcher.Endpoint = "http://haystack-collector/span"
+		}
+	}
+	if c.Dispatcher.Timeout == 0 {
+		c.Dispatcher.Timeout = Duration(3 * time.Second)
+	}
+	if c.Dispatcher.QueueLength == 0 {
+		c.Dispatcher.QueueLength = 1000
+	}
+	if c.SamplingRate == nil {
+		rate := 1.0
+		c.SamplingRate = &rate
+	}
+	if c.Propagation.TextMapCodex == "" {
+		c.Propagation.TextMapCodex = CodexDefault
+	}
+	if c.Propagation.HTTPHeadersCodex == "" {
+		c.Propagation.HTTPHeadersCodex = CodexURL
+	}
+	return c
+}
+
+/*Validate checks the settings once the defaults are applied, the returned *ConfigError lists every problem*/
+func (c Config) Validate() error {
+	c = c.WithDefaults()
+	var problems []string
+
+	if strings.TrimSpace(c.ServiceName) == "" {
+		problems = append(problems, "serviceName is required")
+	}
+	switch c.Dispatcher.Type {
+	case DispatcherTypeAgent:
+		if _, _, err := splitAgentEndpoint(c.Dispatcher.Endpoint); err != nil {
+			problems = append(problems, fmt.Sprintf("dispatcher.endpoint %q must be host:port for the agent dispatcher", c.Dispatcher.Endpoint))
+		}
+	case DispatcherTypeHTTP:
+		if !strings.HasPrefix(c.Dispatcher.Endpoint, "http://") && !strings.HasPrefix(c.Dispatcher.Endpoint, "https://") {
+			problems = append(problems, fmt.Sprintf("dispatcher.endpoint %q must be an http or https url for the http dispatcher", c.Dispatcher.Endpoint))
+		}
+	case DispatcherTypeFile:
+		if c.Dispatcher.Endpoint == "" {
+			problems = append(problems, "dispatcher.endpoint must be the file path for the file dispatcher")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("dispatcher.type %q must be one of %s, %s or %s", c.Dispatcher.Type, DispatcherTypeAgent, DispatcherTypeHTTP, DispatcherTypeFile))
+	}
+	if tlsOptions := c.Dispatcher.TLS; tlsOptions != nil {
+		if c.Dispatcher.Type == DispatcherTypeFile {
+			problems = append(problems, "dispatcher.tls cannot be set for the file dispatcher")
+		}
+		if (tlsOptions.CertFile == "") != (tlsOptions.KeyFile == "") {
+			problems = append(problems, "dispatcher.tls.certFile and dispatcher.tls.keyFile must be set together")
+		}
+	}
+	if c.Dispatcher.Timeout < 0 {
+		problems = append(problems, fmt.Sprintf("dispatcher.timeout %v must be positive", time.Duration(c.Dispatcher.Timeout)))
+	}
+	if c.Dispatcher.QueueLength < 0 {
+		problems = append(problems, fmt.Sprintf("dispatcher.queueLength %d must be positive", c.Dispatcher.QueueLength))
+	}
+	if rate := *c.SamplingRate; rate < 0 || rate > 1 {
+		problems = append(problems, fmt.Sprintf("samplingRate %v must be between 0 and 1", rate))
+	}
+	if _, err := codexByName(c.Propagation.TextMapCodex); err != nil {
+		problems = append(problems, fmt.Sprintf("propagation.textMapCodex %q must be %s, %s or %s", c.Propagation.TextMapCodex, CodexDefault, CodexURL, CodexBase64))
+	}
+	if _, err := codexByName(c.Propagation.HTTPHeadersCodex); err != nil {
+		problems = append(problems, fmt.Sprintf("propagation.httpHeadersCodex %q must be %s, %s or %s", c.Propagation.HTTPHeadersCodex, CodexDefault, CodexURL, CodexBase64))
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+func splitAgentEndpoint(endpoint string) (string, int, error) {
+	host, portValue, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil || host == "" || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid agent endpoint %q", endpoint)
+	}
+	return host, port, nil
+}
+
+func codexByName(name string) (Codex, error) {
+	switch name {
+	case CodexDefault:
+		return DefaultCodex{}, nil
+	case CodexURL:
+		return URLCodex{}, nil
+	case CodexBase64:
+		return Base64Codex{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codex %q", name)
+	}
+}
+
+/*NewTracerFromConfig validates the config and creates the tracer with its dispatcher, the options are applied after the ones derived from the config*/
+func NewTracerFromConfig(config Config, options ...TracerOption) (opentracing.Tracer, io.Closer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	config = config.WithDefaults()
+
+	dispatcher, err := newDispatcherFromConfig(config.Dispatcher)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *config.SamplingRate < 1 {
+		dispatcher = NewSamplingDispatcher(dispatcher, *config.SamplingRate)
+	}
+
+	propagatorOpts := PropagatorOpts{
+		TraceIDKEYName:       config.Propagation.TraceIDKey,
+		SpanIDKEYName:        config.Propagation.SpanIDKey,
+		ParentSpanIDKEYName:  config.Propagation.ParentSpanIDKey,
+		BaggagePrefixKEYName: config.Propagation.BaggagePrefix,
+
+		PreserveBaggageKeyCase: config.Propagation.PreserveBaggageKeyCase,
+	}
+	textMapCodex, _ := codexByName(config.Propagation.TextMapCodex)
+	httpHeadersCodex, _ := codexByName(config.Propagation.HTTPHeadersCodex)
+
+	configOptions := []TracerOption{
+		TracerOptionsFactory.Propagator(opentracing.TextMap, NewTextMapPropagator(propagatorOpts, textMapCodex)),
+		TracerOptionsFactory.Propagator(opentracing.HTTPHeaders, NewTextMapPropagator(propagatorOpts, httpHeadersCodex)),
+	}
+	for key, value := range config.Tags {
+		configOptions = append(configOptions, TracerOptionsFactory.Tag(key, value))
+	}
+	if config.DualSpanMode {
+		configOptions = append(configOptions, TracerOptionsFactory.UseDualSpanMode())
+	}
+
+	tracer, closer := NewTracer(config.ServiceName, dispatcher, append(configOptions, options...)...)
+	return tracer, closer, nil
+}
+
+func newDispatcherFromConfig(config DispatcherConfig) (Dispatcher, error) {
+	timeout := time.Duration(config.Timeout)
+	var options []DispatcherOption
+	if config.TLS != nil {
+		tlsConfig, err := NewTLSConfig(*config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, DispatcherOptionsFactory.Client(ClientOptionsFactory.TLSConfig(tlsConfig)))
+	}
+	if config.DropWhenFull {
+		options = append(options, DispatcherOptionsFactory.DropWhenQueueFull())
+	}
+	switch config.Type {
+	case DispatcherTypeAgent:
+		host, port, err := splitAgentEndpoint(config.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return NewAgentDispatcher(host, port, timeout, config.QueueLength, options...), nil
+	case DispatcherTypeHTTP:
+		headers := config.Headers
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		return NewHTTPDispatcher(config.Endpoint, timeout, headers, config.QueueLength, options...), nil
+	case DispatcherTypeFile:
+		file, err := newRotatingFile(config.Endpoint, FileDispatcherOpts{})
+		if err != nil {
+			return nil, err
+		}
+		return &FileDispatcher{file: file, format: FileFormatJSON, logger: NullLogger{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown dispatcher type %q", config.Type)
+	}
+}
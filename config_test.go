@@ -0,0 +1,216 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type ConfigTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *ConfigTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *ConfigTestSuite) writeFile(name string, content string) string {
+	path := filepath.Join(suite.dir, name)
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func (suite *ConfigTestSuite) TestLoadYAML() {
+	config, err := LoadConfigFile(suite.writeFile("haystack.yaml", `
+serviceName: orders
+dispatcher:
+  type: http
+  endpoint: http://collector:8080/span
+  timeout: 500ms
+  queueLength: 10
+  headers:
+    Client-ID: orders
+tags:
+  appVer: v1.1
+samplingRate: 0.25
+propagation:
+  traceIdKey: X-Trace-ID
+  httpHeadersCodex: default
+dualSpanMode: true
+`))
+	suite.Require().NoError(err)
+	suite.Equal("orders", config.ServiceName)
+	suite.Equal(DispatcherConfig{
+		Type:        DispatcherTypeHTTP,
+		Endpoint:    "http://collector:8080/span",
+		Timeout:     Duration(500 * time.Millisecond),
+		QueueLength: 10,
+		Headers:     map[string]string{"Client-ID": "orders"},
+	}, config.Dispatcher)
+	suite.Equal(map[string]string{"appVer": "v1.1"}, config.Tags)
+	suite.Equal(0.25, *config.SamplingRate)
+	suite.Equal("X-Trace-ID", config.Propagation.TraceIDKey)
+	suite.Equal(CodexDefault, config.Propagation.HTTPHeadersCodex)
+	suite.True(config.DualSpanMode)
+	suite.NoError(config.Validate())
+}
+
+func (suite *ConfigTestSuite) TestLoadJSON() {
+	config, err := LoadConfigFile(suite.writeFile("haystack.json",
+		`{"serviceName": "orders", "dispatcher": {"type": "agent", "endpoint": "localhost:35000", "timeout": "2s"}}`))
+	suite.Require().NoError(err)
+	suite.Equal("localhost:35000", config.Dispatcher.Endpoint)
+	suite.Equal(Duration(2*time.Second), config.Dispatcher.Timeout)
+}
+
+func (suite *ConfigTestSuite) TestLoadRejectsUnknownSettings() {
+	_, err := LoadConfigFile(suite.writeFile("haystack.yaml", "serviceName: orders\nsampling: 0.5\n"))
+	suite.Error(err)
+
+	_, err = LoadConfigFile(suite.writeFile("haystack.toml", "serviceName = 'orders'"))
+	suite.EqualError(err, `unknown config file extension ".toml", expecting .yaml, .yml or .json`)
+}
+
+func (suite *ConfigTestSuite) TestEnvOverridesFile() {
+	suite.T().Setenv("HAYSTACK_SERVICE_NAME", "payments")
+	suite.T().Setenv("HAYSTACK_DISPATCHER_TIMEOUT", "1s")
+	suite.T().Setenv("HAYSTACK_DISPATCHER_DROP_WHEN_FULL", "true")
+	suite.T().Setenv("HAYSTACK_TAGS", "appVer=v2, region=eu")
+	suite.T().Setenv("HAYSTACK_SAMPLING_RATE", "0.5")
+	suite.T().Setenv("HAYSTACK_DUAL_SPAN_MODE", "true")
+	suite.T().Setenv("HAYSTACK_PROPAGATION_PRESERVE_BAGGAGE_KEY_CASE", "1")
+
+	config, err := LoadConfigFile(suite.writeFile("haystack.yaml", "serviceName: orders\ndispatcher:\n  queueLength: 10\n"))
+	suite.Require().NoError(err)
+	suite.Require().NoError(config.ApplyEnv())
+
+	suite.Equal("payments", config.ServiceName)
+	suite.Equal(Duration(time.Second), config.Dispatcher.Timeout)
+	suite.Equal(10, config.Dispatcher.QueueLength)
+	suite.True(config.Dispatcher.DropWhenFull)
+	suite.Equal(map[string]string{"appVer": "v2", "region": "eu"}, config.Tags)
+	suite.Equal(0.5, *config.SamplingRate)
+	suite.True(config.DualSpanMode)
+	suite.True(config.Propagation.PreserveBaggageKeyCase)
+}
+
+func (suite *ConfigTestSuite) TestEnvErrors() {
+	suite.T().Setenv("HAYSTACK_DISPATCHER_QUEUE_LENGTH", "many")
+	suite.T().Setenv("HAYSTACK_TAGS", "appVer")
+
+	_, err := ConfigFromEnv()
+	suite.Require().IsType(&ConfigError{}, err)
+	suite.Equal([]string{
+		`HAYSTACK_DISPATCHER_QUEUE_LENGTH: "many" is not an integer`,
+		`HAYSTACK_TAGS: "appVer" is not a key=value pair`,
+	}, err.(*ConfigError).Problems)
+}
+
+func (suite *ConfigTestSuite) TestTLSFromEnv() {
+	suite.T().Setenv("HAYSTACK_DISPATCHER_TLS_CA_FILE", "/etc/haystack/ca.pem")
+	suite.T().Setenv("HAYSTACK_DISPATCHER_TLS_RELOAD", "true")
+
+	config, err := ConfigFromEnv()
+	suite.Require().NoError(err)
+	suite.Equal(&TLSOptions{CAFile: "/etc/haystack/ca.pem", Reload: true}, config.Dispatcher.TLS)
+
+	suite.T().Setenv("HAYSTACK_DISPATCHER_TLS", "false")
+	config, err = ConfigFromEnv()
+	suite.Require().NoError(err)
+	suite.Nil(config.Dispatcher.TLS, "HAYSTACK_DISPATCHER_TLS takes precedence")
+
+	err = Config{
+		ServiceName: "orders",
+		Dispatcher:  DispatcherConfig{Type: DispatcherTypeFile, Endpoint: "spans.json", TLS: &TLSOptions{CertFile: "client.pem"}},
+	}.Validate()
+	suite.EqualError(err, `invalid haystack config: dispatcher.tls cannot be set for the file dispatcher; `+
+		`dispatcher.tls.certFile and dispatcher.tls.keyFile must be set together`)
+}
+
+func (suite *ConfigTestSuite) TestValidate() {
+	rate := 2.0
+	err := Config{
+		Dispatcher:   DispatcherConfig{Type: DispatcherTypeAgent, Endpoint: "haystack-agent"},
+		SamplingRate: &rate,
+		Propagation:  PropagationConfig{TextMapCodex: "base32"},
+	}.Validate()
+	suite.EqualError(err, `invalid haystack config: serviceName is required; `+
+		`dispatcher.endpoint "haystack-agent" must be host:port for the agent dispatcher; `+
+		`samplingRate 2 must be between 0 and 1; `+
+		`propagation.textMapCodex "base32" must be default, url or base64`)
+
+	err = Config{ServiceName: "orders", Dispatcher: DispatcherConfig{Type: "kafka"}}.Validate()
+	suite.EqualError(err, `invalid haystack config: dispatcher.type "kafka" must be one of agent, http or file`)
+
+	suite.NoError(Config{ServiceName: "orders"}.Validate())
+}
+
+func (suite *ConfigTestSuite) TestNewTracerFromConfig() {
+	path := filepath.Join(suite.dir, "spans.json")
+	tracer, closer, err := NewTracerFromConfig(Config{
+		ServiceName: "orders",
+		Dispatcher:  DispatcherConfig{Type: DispatcherTypeFile, Endpoint: path},
+		Tags:        map[string]string{"appVer": "v1.1"},
+		Propagation: PropagationConfig{TraceIDKey: "X-Trace-ID"},
+	})
+	suite.Require().NoError(err)
+
+	span := tracer.StartSpan("checkout")
+	carrier := opentracing.TextMapCarrier{}
+	suite.NoError(tracer.Inject(span.Context(), opentracing.TextMap, carrier))
+	suite.Equal(span.Context().(*SpanContext).TraceID, carrier["X-Trace-ID"])
+	span.Finish()
+	suite.NoError(closer.Close())
+
+	data, err := ioutil.ReadFile(path)
+	suite.Require().NoError(err)
+	suite.Contains(string(data), `"serviceName":"orders"`)
+	suite.Contains(string(data), `"appVer"`)
+
+	_, _, err = NewTracerFromConfig(Config{})
+	suite.EqualError(err, "invalid haystack config: serviceName is required")
+}
+
+func (suite *ConfigTestSuite) TestNewTracerFromConfigSampling() {
+	path := filepath.Join(suite.dir, "spans.json")
+	rate := 0.0
+	tracer, closer, err := NewTracerFromConfig(Config{
+		ServiceName:  "orders",
+		Dispatcher:   DispatcherConfig{Type: DispatcherTypeFile, Endpoint: path},
+		SamplingRate: &rate,
+	})
+	suite.Require().NoError(err)
+	tracer.StartSpan("checkout").Finish()
+	suite.NoError(closer.Close())
+
+	info, err := os.Stat(path)
+	suite.Require().NoError(err)
+	suite.Zero(info.Size())
+}
+
+func TestUnitConfigSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
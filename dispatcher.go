@@ -21,8 +21,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
 )
@@ -30,62 +32,52 @@ import (
 /*Dispatcher dispatches the span object*/
 type Dispatcher interface {
 	Name() string
-	Dispatch(span *_Span)
+	Dispatch(span FinishedSpan)
 	DispatchProtoSpan(span *Span)
 	Close()
 	SetLogger(logger Logger)
 }
 
-/*InMemoryDispatcher implements the Dispatcher interface*/
-type InMemoryDispatcher struct {
-	spans  []*_Span
-	logger Logger
-}
+/*FileDispatcherOpts defines the output format and the rotation policy of a file dispatcher, zero values disable the respective limit*/
+type FileDispatcherOpts struct {
+	// Format of the span records, defaults to json lines
+	Format FileFormat
 
-/*NewInMemoryDispatcher creates a new in memory dispatcher*/
-func NewInMemoryDispatcher() Dispatcher {
-	return &InMemoryDispatcher{}
-}
+	// MaxSizeBytes rotates the file before a write would take it past this size
+	MaxSizeBytes int64
 
-/*Name gives the Dispatcher name*/
-func (d *InMemoryDispatcher) Name() string {
-	return "InMemoryDispatcher"
-}
+	// MaxAge rotates the file once it has been open for this long
+	MaxAge time.Duration
 
-/*SetLogger sets the logger to use*/
-func (d *InMemoryDispatcher) SetLogger(logger Logger) {
-	d.logger = logger
-}
+	// MaxBackups is the number of rotated files to retain, older ones are removed
+	MaxBackups int
 
-/*Dispatch dispatches the span object*/
-func (d *InMemoryDispatcher) Dispatch(span *_Span) {
-	d.spans = append(d.spans, span)
-}
-
-/*DispatchProtoSpan dispatches proto span object*/
-func (d *InMemoryDispatcher) DispatchProtoSpan(span *Span) {
-	/* not implemented */
-}
-
-/*Close down the inMemory dispatcher*/
-func (d *InMemoryDispatcher) Close() {
-	d.spans = nil
+	// Compress gzips the rotated files
+	Compress bool
 }
 
 /*FileDispatcher file dispatcher*/
 type FileDispatcher struct {
-	fileHandle *os.File
-	logger     Logger
+	file   *rotatingFile
+	format FileFormat
+	logger Logger
 }
 
-/*NewFileDispatcher creates a new file dispatcher*/
+/*NewFileDispatcher creates a new file dispatcher that appends to the file without rotating it*/
 func NewFileDispatcher(filename string) Dispatcher {
-	fd, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	return NewRotatingFileDispatcher(filename, FileDispatcherOpts{})
+}
+
+/*NewRotatingFileDispatcher creates a new file dispatcher that rotates the file as per the given options*/
+func NewRotatingFileDispatcher(filename string, opts FileDispatcherOpts) Dispatcher {
+	file, err := newRotatingFile(filename, opts)
 	if err != nil {
 		panic(err)
 	}
 	return &FileDispatcher{
-		fileHandle: fd,
+		file:   file,
+		format: opts.Format,
+		logger: NullLogger{},
 	}
 }
 
@@ -97,24 +89,38 @@ func (d *FileDispatcher) Name() string {
 /*SetLogger sets the logger to use*/
 func (d *FileDispatcher) SetLogger(logger Logger) {
 	d.logger = logger
+	d.file.setLogger(logger)
 }
 
-/*Dispatch dispatches the span object*/
-func (d *FileDispatcher) Dispatch(span *_Span) {
-	_, err := d.fileHandle.WriteString(span.String() + "\n")
+/*Dispatch dispatches the span object, the spans that cannot be written are logged and dropped*/
+func (d *FileDispatcher) Dispatch(span FinishedSpan) {
+	record, err := encodeSpan(d.format, span)
 	if err != nil {
-		panic(err)
+		d.logger.Error("Fail to encode the span %s as %v, error=%v", span.OperationName(), d.format, err)
+		return
 	}
+	d.write(record)
 }
 
-/*DispatchProtoSpan dispatches proto span object*/
+/*DispatchProtoSpan dispatches proto span object, the spans that cannot be written are logged and dropped*/
 func (d *FileDispatcher) DispatchProtoSpan(span *Span) {
-	/* not implemented */
+	record, err := encodeProtoSpan(d.format, span)
+	if err != nil {
+		d.logger.Error("Fail to encode the span %s as %v, error=%v", span.GetOperationName(), d.format, err)
+		return
+	}
+	d.write(record)
+}
+
+func (d *FileDispatcher) write(record []byte) {
+	if _, err := d.file.Write(record); err != nil {
+		d.logger.Error("Fail to write the span to the file, error=%v", err)
+	}
 }
 
 /*Close down the file dispatcher*/
 func (d *FileDispatcher) Close() {
-	err := d.fileHandle.Close()
+	err := d.file.Close()
 	if err != nil {
 		panic(err)
 	}
@@ -122,18 +128,58 @@ func (d *FileDispatcher) Close() {
 
 /*RemoteDispatcher dispatcher, client can be grpc or http*/
 type RemoteDispatcher struct {
-	client      RemoteClient
-	timeout     time.Duration
-	logger      Logger
-	spanChannel chan *Span
+	stats        dispatcherStats
+	client       RemoteClient
+	timeout      time.Duration
+	logger       Logger
+	spanChannel  chan *Span
+	dropWhenFull bool
+}
+
+// DispatcherOption is a function that sets some option on a remote dispatcher
+type DispatcherOption func(opts *dispatcherOptions)
+
+type dispatcherOptions struct {
+	clientOptions []ClientOption
+	dropWhenFull  bool
+}
+
+/*DispatcherOptions a list of remote dispatcher options*/
+type DispatcherOptions struct{}
+
+/*DispatcherOptionsFactory factory to create multiple remote dispatcher options*/
+var DispatcherOptionsFactory DispatcherOptions
+
+/*Client passes the options to the remote client of the dispatcher*/
+func (d DispatcherOptions) Client(options ...ClientOption) DispatcherOption {
+	return func(opts *dispatcherOptions) {
+		opts.clientOptions = append(opts.clientOptions, options...)
+	}
+}
+
+/*DropWhenQueueFull drops the spans instead of blocking the application when the queue is full, the drops are counted in the stats*/
+func (d DispatcherOptions) DropWhenQueueFull() DispatcherOption {
+	return func(opts *dispatcherOptions) {
+		opts.dropWhenFull = true
+	}
+}
+
+func newDispatcherOptions(options []DispatcherOption) dispatcherOptions {
+	opts := dispatcherOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
 }
 
 /*NewHTTPDispatcher creates a new haystack-agent dispatcher*/
-func NewHTTPDispatcher(url string, timeout time.Duration, headers map[string]string, maxQueueLength int) Dispatcher {
+func NewHTTPDispatcher(url string, timeout time.Duration, headers map[string]string, maxQueueLength int, options ...DispatcherOption) Dispatcher {
+	opts := newDispatcherOptions(options)
 	dispatcher := &RemoteDispatcher{
-		client:      NewHTTPClient(url, headers, timeout),
-		timeout:     timeout,
-		spanChannel: make(chan *Span, maxQueueLength),
+		client:       NewHTTPClient(url, headers, timeout, opts.clientOptions...),
+		timeout:      timeout,
+		spanChannel:  make(chan *Span, maxQueueLength),
+		dropWhenFull: opts.dropWhenFull,
 	}
 
 	go startListener(dispatcher)
@@ -146,11 +192,13 @@ func NewDefaultHTTPDispatcher() Dispatcher {
 }
 
 /*NewAgentDispatcher creates a new haystack-agent dispatcher*/
-func NewAgentDispatcher(host string, port int, timeout time.Duration, maxQueueLength int) Dispatcher {
+func NewAgentDispatcher(host string, port int, timeout time.Duration, maxQueueLength int, options ...DispatcherOption) Dispatcher {
+	opts := newDispatcherOptions(options)
 	dispatcher := &RemoteDispatcher{
-		client:      NewGrpcClient(host, port, timeout),
-		timeout:     timeout,
-		spanChannel: make(chan *Span, maxQueueLength),
+		client:       NewGrpcClient(host, port, timeout, opts.clientOptions...),
+		timeout:      timeout,
+		spanChannel:  make(chan *Span, maxQueueLength),
+		dropWhenFull: opts.dropWhenFull,
 	}
 
 	go startListener(dispatcher)
@@ -169,13 +217,24 @@ func startListener(dispatcher *RemoteDispatcher) {
 	for {
 		select {
 		case sp := <-dispatcher.spanChannel:
-			dispatcher.client.Send(sp)
+			start := time.Now()
+			err := send(dispatcher.client, sp)
+			dispatcher.stats.recordSend(time.Since(start), err)
 		case <-signals:
 			break
 		}
 	}
 }
 
+// send sends the span, the result is only known for a ReportingClient
+func send(client RemoteClient, span *Span) error {
+	if reporting, ok := client.(ReportingClient); ok {
+		return reporting.TrySend(span)
+	}
+	client.Send(span)
+	return nil
+}
+
 /*Name gives the Dispatcher name*/
 func (d *RemoteDispatcher) Name() string {
 	return "RemoteDispatcher"
@@ -187,39 +246,67 @@ func (d *RemoteDispatcher) SetLogger(logger Logger) {
 	d.client.SetLogger(logger)
 }
 
-/*Dispatch dispatches the span object*/
-func (d *RemoteDispatcher) Dispatch(span *_Span) {
-	s := &Span{
-		TraceId:       span.context.TraceID,
-		SpanId:        span.context.SpanID,
-		ParentSpanId:  span.context.ParentID,
-		ServiceName:   span.ServiceName(),
-		OperationName: span.OperationName(),
-		StartTime:     span.startTime.UnixNano() / int64(time.Microsecond),
-		Duration:      span.duration.Nanoseconds() / int64(time.Microsecond),
-		Tags:          d.tags(span),
-		Logs:          d.logs(span),
-	}
-	d.spanChannel <- s
+/*Dispatch dispatches the span object, it blocks while the queue is full unless DispatcherOptionsFactory.DropWhenQueueFull is set*/
+func (d *RemoteDispatcher) Dispatch(span FinishedSpan) {
+	d.enqueue(toProtoSpan(span))
 }
 
-/*DispatchProtoSpan dispatches the proto span object*/
+/*DispatchProtoSpan dispatches the proto span object, it blocks while the queue is full unless DispatcherOptionsFactory.DropWhenQueueFull is set*/
 func (d *RemoteDispatcher) DispatchProtoSpan(s *Span) {
-	d.spanChannel <- s
+	d.enqueue(s)
 }
 
-func (d *RemoteDispatcher) logs(span *_Span) []*Log {
+func (d *RemoteDispatcher) enqueue(s *Span) {
+	if !d.dropWhenFull {
+		d.spanChannel <- s
+		atomic.AddInt64(&d.stats.enqueued, 1)
+		return
+	}
+	select {
+	case d.spanChannel <- s:
+		atomic.AddInt64(&d.stats.enqueued, 1)
+	default:
+		// the first drop is an error so that an undersized queue does not go unnoticed
+		if atomic.AddInt64(&d.stats.dropped, 1) == 1 {
+			d.logger.Error("span queue of %d is full, dropping span %s of trace %s and the next ones, see the dropped spans stat", cap(d.spanChannel), s.GetSpanId(), s.GetTraceId())
+		} else {
+			d.logger.Debug("span queue is full, dropping span %s of trace %s", s.GetSpanId(), s.GetTraceId())
+		}
+	}
+}
+
+/*Stats returns the self metrics of the dispatcher*/
+func (d *RemoteDispatcher) Stats() Stats {
+	return d.stats.snapshot(d.spanChannel)
+}
+
+func toProtoSpan(span FinishedSpan) *Span {
+	spanContext := finishedSpanContext(span)
+	return &Span{
+		TraceId:       spanContext.TraceID,
+		SpanId:        spanContext.SpanID,
+		ParentSpanId:  spanContext.ParentID,
+		ServiceName:   span.ServiceName(),
+		OperationName: span.OperationName(),
+		StartTime:     toMicros(span.StartTime()),
+		Duration:      span.Duration().Nanoseconds() / int64(time.Microsecond),
+		Tags:          protoTags(span.Tags()),
+		Logs:          protoLogs(span.Logs()),
+	}
+}
+
+func protoLogs(logs []opentracing.LogRecord) []*Log {
 	var spanLogs []*Log
-	for _, lg := range span.logs {
+	for _, lg := range logs {
 		spanLogs = append(spanLogs, &Log{
-			Timestamp: lg.Timestamp.UnixNano() / int64(time.Microsecond),
-			Fields:    d.logFieldsToTags(lg.Fields),
+			Timestamp: toMicros(lg.Timestamp),
+			Fields:    logFieldsToProtoTags(lg.Fields),
 		})
 	}
 	return spanLogs
 }
 
-func (d *RemoteDispatcher) logFieldsToTags(fields []log.Field) []*Tag {
+func logFieldsToProtoTags(fields []log.Field) []*Tag {
 	var spanTags []*Tag
 	for _, field := range fields {
 		spanTags = append(spanTags, ConvertToProtoTag(field.Key(), field.Value()))
@@ -227,9 +314,9 @@ func (d *RemoteDispatcher) logFieldsToTags(fields []log.Field) []*Tag {
 	return spanTags
 }
 
-func (d *RemoteDispatcher) tags(span *_Span) []*Tag {
+func protoTags(tags []opentracing.Tag) []*Tag {
 	var spanTags []*Tag
-	for _, tag := range span.tags {
+	for _, tag := range tags {
 		spanTags = append(spanTags, ConvertToProtoTag(tag.Key, tag.Value))
 	}
 	return spanTags
@@ -243,7 +330,7 @@ func (d *RemoteDispatcher) Close() {
 	}
 }
 
-/*ConvertToProtoTag converts to proto tag*/
+/*ConvertToProtoTag converts to proto tag, the values of unsupported types become strings*/
 func ConvertToProtoTag(key string, value interface{}) *Tag {
 	switch v := value.(type) {
 	case string:
@@ -350,7 +437,22 @@ func ConvertToProtoTag(key string, value interface{}) *Tag {
 			},
 			Type: Tag_STRING,
 		}
+	case error:
+		return &Tag{
+			Key: key,
+			Myvalue: &Tag_VStr{
+				VStr: value.(error).Error(),
+			},
+			Type: Tag_STRING,
+		}
 	default:
-		panic(fmt.Errorf("unknown format %v", v))
+		// values of other types, e.g. structs or time.Time, are sent as their default string format
+		return &Tag{
+			Key: key,
+			Myvalue: &Tag_VStr{
+				VStr: fmt.Sprint(v),
+			},
+			Type: Tag_STRING,
+		}
 	}
 }
@@ -0,0 +1,62 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack_test
+
+import (
+	"testing"
+	"time"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// operationDispatcher is a third party dispatcher that only needs the exported api
+type operationDispatcher struct {
+	operations []string
+	durations  []time.Duration
+	traceIDs   []string
+}
+
+func (d *operationDispatcher) Name() string                     { return "operationDispatcher" }
+func (d *operationDispatcher) DispatchProtoSpan(*haystack.Span) {}
+func (d *operationDispatcher) Close()                           {}
+func (d *operationDispatcher) SetLogger(logger haystack.Logger) {}
+
+func (d *operationDispatcher) Dispatch(span haystack.FinishedSpan) {
+	d.operations = append(d.operations, span.OperationName())
+	d.durations = append(d.durations, span.Duration())
+	d.traceIDs = append(d.traceIDs, span.Context().(*haystack.SpanContext).TraceID)
+}
+
+func TestUnitCustomDispatcher(t *testing.T) {
+	dispatcher := &operationDispatcher{}
+	tracer, closer := haystack.NewTracer("my-service", dispatcher)
+	defer func() {
+		assert.NoError(t, closer.Close())
+	}()
+
+	start := time.Now()
+	parent := tracer.StartSpan("op1", opentracing.StartTime(start))
+	tracer.StartSpan("op2", opentracing.ChildOf(parent.Context())).Finish()
+	parent.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(time.Second)})
+
+	assert.Equal(t, []string{"op2", "op1"}, dispatcher.operations)
+	assert.Equal(t, time.Second, dispatcher.durations[1])
+	assert.Equal(t, dispatcher.traceIDs[0], dispatcher.traceIDs[1])
+}
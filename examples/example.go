@@ -18,7 +18,6 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
@@ -27,29 +26,9 @@ import (
 	"github.com/opentracing/opentracing-go/ext"
 )
 
-type consoleLogger struct{}
-
-/*Error prints the error message*/
-func (logger *consoleLogger) Error(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-	fmt.Print("\n")
-}
-
-/*Info prints the info message*/
-func (logger *consoleLogger) Info(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-	fmt.Print("\n")
-}
-
-/*Debug prints the info message*/
-func (logger *consoleLogger) Debug(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-	fmt.Print("\n")
-}
-
 func main() {
 	/*Use haystack.NewDefaultAgentDispatcher() for non-dev environment*/
-	tracer, closer := haystack.NewTracer("dummy-service", haystack.NewDefaultAgentDispatcher(), haystack.TracerOptionsFactory.Tag("appVer", "v1.1"), haystack.TracerOptionsFactory.Logger(&consoleLogger{}))
+	tracer, closer := haystack.NewTracer("dummy-service", haystack.NewDefaultAgentDispatcher(), haystack.TracerOptionsFactory.Tag("appVer", "v1.1"), haystack.TracerOptionsFactory.Logger(haystack.NewStdLogger(nil)))
 	defer func() {
 		err := closer.Close()
 		if err != nil {
@@ -0,0 +1,171 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+/*FileFormat defines how a file dispatcher serializes the spans*/
+type FileFormat int
+
+const (
+	// FileFormatJSON writes one proto Span per line, as json
+	FileFormatJSON FileFormat = iota
+
+	// FileFormatProtoDelimited writes proto Span records, each prefixed with its varint encoded length
+	FileFormatProtoDelimited
+
+	// FileFormatProtoBase64 writes one base64 encoded proto Span per line
+	FileFormatProtoBase64
+)
+
+/*String returns the name of the format*/
+func (f FileFormat) String() string {
+	switch f {
+	case FileFormatJSON:
+		return "json"
+	case FileFormatProtoDelimited:
+		return "proto-delimited"
+	case FileFormatProtoBase64:
+		return "proto-base64"
+	default:
+		return fmt.Sprintf("FileFormat(%d)", int(f))
+	}
+}
+
+var protoJSONMarshaler = jsonpb.Marshaler{}
+
+// encodeSpan serializes the span as a single record of the given format, as its proto span so that the records of
+// Dispatch and DispatchProtoSpan have the same schema
+func encodeSpan(format FileFormat, span FinishedSpan) ([]byte, error) {
+	return encodeProtoSpan(format, toProtoSpan(span))
+}
+
+// encodeProtoSpan serializes the proto span as a single record of the given format
+func encodeProtoSpan(format FileFormat, span *Span) ([]byte, error) {
+	switch format {
+	case FileFormatJSON:
+		data, err := protoJSONMarshaler.MarshalToString(span)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(data + "\n"), nil
+	case FileFormatProtoDelimited:
+		data, err := proto.Marshal(span)
+		if err != nil {
+			return nil, err
+		}
+		return append(proto.EncodeVarint(uint64(len(data))), data...), nil
+	case FileFormatProtoBase64:
+		data, err := proto.Marshal(span)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(base64.StdEncoding.EncodeToString(data) + "\n"), nil
+	default:
+		return nil, fmt.Errorf("unknown file format %v", format)
+	}
+}
+
+/*ReadProtoSpans reads the spans written by a file dispatcher in one of the proto formats and calls the handler for each of them, e.g. to replay them to haystack-agent*/
+func ReadProtoSpans(reader io.Reader, format FileFormat, handler func(span *Span) error) error {
+	switch format {
+	case FileFormatProtoDelimited:
+		return readDelimitedSpans(bufio.NewReader(reader), handler)
+	case FileFormatProtoBase64:
+		return readBase64Spans(reader, handler)
+	default:
+		return fmt.Errorf("spans can not be read back from file format %v", format)
+	}
+}
+
+func readDelimitedSpans(reader *bufio.Reader, handler func(span *Span) error) error {
+	for {
+		length, err := readVarint(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		data := make([]byte, length)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("fail to read a span record of %d bytes: %v", length, err)
+		}
+
+		span := &Span{}
+		if err = proto.Unmarshal(data, span); err != nil {
+			return err
+		}
+		if err = handler(span); err != nil {
+			return err
+		}
+	}
+}
+
+func readVarint(reader io.ByteReader) (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && shift > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("span record length overflows a 64-bit varint")
+}
+
+func readBase64Spans(reader io.Reader, handler func(span *Span) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		data := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+		n, err := base64.StdEncoding.Decode(data, line)
+		if err != nil {
+			return err
+		}
+
+		span := &Span{}
+		if err = proto.Unmarshal(data[:n], span); err != nil {
+			return err
+		}
+		if err = handler(span); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,160 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type FileFormatTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (suite *FileFormatTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "haystack-spans")
+	suite.Require().NoError(err)
+	suite.dir = dir
+}
+
+func (suite *FileFormatTestSuite) TearDownTest() {
+	suite.Require().NoError(os.RemoveAll(suite.dir))
+}
+
+func (suite *FileFormatTestSuite) TestProtoFormatsRoundTrip() {
+	for _, format := range []FileFormat{FileFormatProtoDelimited, FileFormatProtoBase64} {
+		filename := filepath.Join(suite.dir, format.String())
+		dispatcher := NewRotatingFileDispatcher(filename, FileDispatcherOpts{Format: format})
+		tracer, closer := NewTracer("my-service", dispatcher, TracerOptionsFactory.Tag("t1", "v1"))
+
+		span := tracer.StartSpan("op1")
+		span.SetTag("count", 42)
+		span.LogKV("event", "cache-miss")
+		span.Finish()
+
+		protoSpan := &Span{TraceId: "T1", SpanId: "S1", ServiceName: "other-service", OperationName: "op2"}
+		dispatcher.DispatchProtoSpan(protoSpan)
+		suite.Require().NoError(closer.Close())
+
+		fd, err := os.Open(filename)
+		suite.Require().NoError(err)
+
+		var spans []*Span
+		err = ReadProtoSpans(fd, format, func(span *Span) error {
+			spans = append(spans, span)
+			return nil
+		})
+		suite.Require().NoError(err)
+		suite.Require().NoError(fd.Close())
+
+		suite.Require().Len(spans, 2, "format %v", format)
+		suite.Equal("op1", spans[0].GetOperationName())
+		suite.Equal("my-service", spans[0].GetServiceName())
+		suite.Len(spans[0].GetTags(), 2)
+		suite.Len(spans[0].GetLogs(), 1)
+		suite.True(proto.Equal(protoSpan, spans[1]), "proto span should be read back unchanged")
+	}
+}
+
+func (suite *FileFormatTestSuite) TestJSONFormatWritesProtoSpans() {
+	filename := filepath.Join(suite.dir, "spans.json")
+	dispatcher := NewFileDispatcher(filename)
+	dispatcher.DispatchProtoSpan(&Span{TraceId: "T1", SpanId: "S1", OperationName: "op1"})
+	dispatcher.Close()
+
+	data, err := ioutil.ReadFile(filename)
+	suite.Require().NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	suite.Require().Len(lines, 1)
+
+	var record map[string]interface{}
+	suite.Require().NoError(json.Unmarshal([]byte(lines[0]), &record))
+	suite.Equal("T1", record["traceId"])
+	suite.Equal("op1", record["operationName"])
+}
+
+func (suite *FileFormatTestSuite) TestWriteErrorsAreLogged() {
+	var logs bytes.Buffer
+	dispatcher := NewRotatingFileDispatcher(filepath.Join(suite.dir, "spans"), FileDispatcherOpts{Format: FileFormat(42)})
+	dispatcher.SetLogger(NewStdLogger(log.New(&logs, "", 0)))
+
+	suite.NotPanics(func() {
+		dispatcher.DispatchProtoSpan(&Span{TraceId: "T1", SpanId: "S1", OperationName: "op1"})
+	})
+	suite.Contains(logs.String(), "ERROR Fail to encode the span op1 as FileFormat(42), error=unknown file format FileFormat(42)")
+
+	dispatcher.(*FileDispatcher).format = FileFormatJSON
+	dispatcher.Close()
+	suite.NotPanics(func() {
+		dispatcher.DispatchProtoSpan(&Span{TraceId: "T1", SpanId: "S2", OperationName: "op2"})
+	})
+	suite.Contains(logs.String(), "ERROR Fail to write the span to the file, error="+os.ErrClosed.Error())
+}
+
+func (suite *FileFormatTestSuite) TestJSONRecordsShareTheProtoSchema() {
+	filename := filepath.Join(suite.dir, "spans.json")
+	dispatcher := NewFileDispatcher(filename)
+	tracer, closer := NewTracer("my-service", dispatcher)
+
+	start := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	span := tracer.StartSpan("op1", opentracing.StartTime(start))
+	span.LogKV("event", "cache-miss")
+	span.FinishWithOptions(opentracing.FinishOptions{FinishTime: start.Add(time.Millisecond)})
+	dispatcher.DispatchProtoSpan(&Span{TraceId: "T1", SpanId: "S1", ServiceName: "other-service", OperationName: "op2", StartTime: 1, Duration: 2})
+	suite.Require().NoError(closer.Close())
+
+	data, err := ioutil.ReadFile(filename)
+	suite.Require().NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	suite.Require().Len(lines, 2)
+
+	var records [2]map[string]interface{}
+	for i, line := range lines {
+		suite.Require().NoError(json.Unmarshal([]byte(line), &records[i]))
+		suite.Contains(records[i], "startTime", "record %s", line)
+		suite.Contains(records[i], "duration", "record %s", line)
+	}
+	suite.Equal("op1", records[0]["operationName"])
+	suite.Equal("op2", records[1]["operationName"])
+
+	logs := records[0]["logs"].([]interface{})
+	suite.Require().Len(logs, 1)
+	field := logs[0].(map[string]interface{})["fields"].([]interface{})[0].(map[string]interface{})
+	suite.Equal(map[string]interface{}{"key": "event", "vStr": "cache-miss"}, field)
+}
+
+func (suite *FileFormatTestSuite) TestJSONFormatCanNotBeReadBack() {
+	err := ReadProtoSpans(strings.NewReader("{}\n"), FileFormatJSON, func(span *Span) error { return nil })
+	suite.Error(err)
+}
+
+func TestUnitFileFormatSuite(t *testing.T) {
+	suite.Run(t, new(FileFormatTestSuite))
+}
@@ -0,0 +1,355 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackgrpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MethodTagKey is the tag holding the full grpc method name
+	MethodTagKey = "grpc.method"
+
+	// StatusCodeTagKey is the tag holding the grpc status code name
+	StatusCodeTagKey = "grpc.status_code"
+
+	componentName = "gRPC"
+)
+
+// InterceptorOption is a function that sets some option on the interceptors
+type InterceptorOption func(opts *interceptorOptions)
+
+type interceptorOptions struct {
+	operationName func(fullMethod string) string
+	filter        func(ctx context.Context, fullMethod string) bool
+	logPayloads   bool
+}
+
+/*InterceptorOptions a list of interceptor options*/
+type InterceptorOptions struct{}
+
+/*InterceptorOptionsFactory factory to create multiple interceptor options*/
+var InterceptorOptionsFactory InterceptorOptions
+
+/*OperationName sets the function naming the span, defaults to the full method name*/
+func (o InterceptorOptions) OperationName(f func(fullMethod string) string) InterceptorOption {
+	return func(opts *interceptorOptions) {
+		opts.operationName = f
+	}
+}
+
+/*Filter sets the function deciding whether the call is traced*/
+func (o InterceptorOptions) Filter(f func(ctx context.Context, fullMethod string) bool) InterceptorOption {
+	return func(opts *interceptorOptions) {
+		opts.filter = f
+	}
+}
+
+/*LogPayloads logs every request and response message on the span*/
+func (o InterceptorOptions) LogPayloads() InterceptorOption {
+	return func(opts *interceptorOptions) {
+		opts.logPayloads = true
+	}
+}
+
+func newInterceptorOptions(options []InterceptorOption) *interceptorOptions {
+	opts := &interceptorOptions{
+		operationName: func(fullMethod string) string { return fullMethod },
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+func (opts *interceptorOptions) traced(ctx context.Context, fullMethod string) bool {
+	return opts.filter == nil || opts.filter(ctx, fullMethod)
+}
+
+/*UnaryClientInterceptor runs every unary call in a client span and propagates its context in the outgoing metadata*/
+func UnaryClientInterceptor(tracer opentracing.Tracer, options ...InterceptorOption) grpc.UnaryClientInterceptor {
+	opts := newInterceptorOptions(options)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		if !opts.traced(ctx, method) {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+
+		span, ctx := startClientSpan(ctx, tracer, opts, method)
+		if opts.logPayloads {
+			logPayload(span, "request", req)
+		}
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		if err == nil && opts.logPayloads {
+			logPayload(span, "response", reply)
+		}
+		finishSpan(span, err)
+		return err
+	}
+}
+
+/*StreamClientInterceptor runs every stream in a client span, finished once the stream ends, and propagates its context in the outgoing metadata*/
+func StreamClientInterceptor(tracer opentracing.Tracer, options ...InterceptorOption) grpc.StreamClientInterceptor {
+	opts := newInterceptorOptions(options)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if !opts.traced(ctx, method) {
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+
+		span, ctx := startClientSpan(ctx, tracer, opts, method)
+		stream, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			finishSpan(span, err)
+			return nil, err
+		}
+		tracedStream := &tracedClientStream{
+			ClientStream: stream,
+			span:         span,
+			opts:         opts,
+			desc:         desc,
+			done:         make(chan struct{}),
+		}
+		// the caller may abandon the stream by cancelling its context without reading it to the end
+		go func() {
+			select {
+			case <-tracedStream.done:
+			case <-ctx.Done():
+				tracedStream.finish(contextError(ctx.Err()))
+			}
+		}()
+		return tracedStream, nil
+	}
+}
+
+/*UnaryServerInterceptor runs every unary call in a server span, available from the handler's context with opentracing.SpanFromContext*/
+func UnaryServerInterceptor(tracer opentracing.Tracer, options ...InterceptorOption) grpc.UnaryServerInterceptor {
+	opts := newInterceptorOptions(options)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !opts.traced(ctx, info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		span, ctx := startServerSpan(ctx, tracer, opts, info.FullMethod)
+		if opts.logPayloads {
+			logPayload(span, "request", req)
+		}
+		resp, err := handler(ctx, req)
+		if err == nil && opts.logPayloads {
+			logPayload(span, "response", resp)
+		}
+		finishSpan(span, err)
+		return resp, err
+	}
+}
+
+/*StreamServerInterceptor runs every stream in a server span, available from the stream's context with opentracing.SpanFromContext*/
+func StreamServerInterceptor(tracer opentracing.Tracer, options ...InterceptorOption) grpc.StreamServerInterceptor {
+	opts := newInterceptorOptions(options)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !opts.traced(stream.Context(), info.FullMethod) {
+			return handler(srv, stream)
+		}
+
+		span, ctx := startServerSpan(stream.Context(), tracer, opts, info.FullMethod)
+		err := handler(srv, &tracedServerStream{
+			ServerStream: stream,
+			ctx:          ctx,
+			span:         span,
+			opts:         opts,
+		})
+		finishSpan(span, err)
+		return err
+	}
+}
+
+func startClientSpan(ctx context.Context, tracer opentracing.Tracer, opts *interceptorOptions, method string) (opentracing.Span, context.Context) {
+	startOptions := []opentracing.StartSpanOption{
+		ext.SpanKindRPCClient,
+		opentracing.Tag{Key: string(ext.Component), Value: componentName},
+		opentracing.Tag{Key: MethodTagKey, Value: method},
+	}
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		startOptions = append(startOptions, opentracing.ChildOf(parent.Context()))
+	}
+	span := tracer.StartSpan(opts.operationName(method), startOptions...)
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.New(nil)
+	}
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, MetadataCarrier(md)); err != nil {
+		span.LogFields(log.String("event", "inject-failed"), log.String("message", err.Error()))
+	}
+	return span, opentracing.ContextWithSpan(metadata.NewOutgoingContext(ctx, md), span)
+}
+
+func startServerSpan(ctx context.Context, tracer opentracing.Tracer, opts *interceptorOptions, method string) (opentracing.Span, context.Context) {
+	// span.kind=server lets the tracer share the span id of the client in single span mode
+	startOptions := []opentracing.StartSpanOption{
+		ext.SpanKindRPCServer,
+		opentracing.Tag{Key: string(ext.Component), Value: componentName},
+		opentracing.Tag{Key: MethodTagKey, Value: method},
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if upstreamContext, err := tracer.Extract(opentracing.TextMap, MetadataCarrier(md)); err == nil {
+			startOptions = append(startOptions, opentracing.ChildOf(upstreamContext))
+		}
+	}
+	span := tracer.StartSpan(opts.operationName(method), startOptions...)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
+
+func finishSpan(span opentracing.Span, err error) {
+	code := status.Code(err)
+	span.SetTag(StatusCodeTagKey, code.String())
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+	}
+	span.Finish()
+}
+
+func contextError(err error) error {
+	switch err {
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return err
+	}
+}
+
+func logPayload(span opentracing.Span, event string, msg interface{}) {
+	span.LogFields(log.String("event", event), log.String("message", fmt.Sprintf("%v", msg)))
+}
+
+// tracedClientStream finishes the client span once the stream ends
+type tracedClientStream struct {
+	grpc.ClientStream
+	span opentracing.Span
+	opts *interceptorOptions
+	desc *grpc.StreamDesc
+
+	mu       sync.Mutex
+	finished bool
+	done     chan struct{}
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		s.finish(err)
+	} else if s.opts.logPayloads {
+		s.log("send", m)
+	}
+	return err
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.finish(nil)
+		return err
+	}
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	if s.opts.logPayloads {
+		s.log("receive", m)
+	}
+	// a stream without server streaming ends with its only response
+	if !s.desc.ServerStreams {
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *tracedClientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *tracedClientStream) log(event string, m interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.finished {
+		logPayload(s.span, event, m)
+	}
+}
+
+func (s *tracedClientStream) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.finished {
+		s.finished = true
+		close(s.done)
+		finishSpan(s.span, err)
+	}
+}
+
+// tracedServerStream exposes the server span in the stream context
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	span opentracing.Span
+	opts *interceptorOptions
+	mu   sync.Mutex
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *tracedServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil && s.opts.logPayloads {
+		s.log("send", m)
+	}
+	return err
+}
+
+func (s *tracedServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.opts.logPayloads {
+		s.log("receive", m)
+	}
+	return err
+}
+
+func (s *tracedServerStream) log(event string, m interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logPayload(s.span, event, m)
+}
@@ -0,0 +1,128 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackgrpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type InterceptorTestSuite struct {
+	suite.Suite
+	dispatcher *haystack.InMemoryDispatcher
+	tracer     opentracing.Tracer
+	closer     io.Closer
+	server     *grpc.Server
+	conn       *grpc.ClientConn
+	client     healthpb.HealthClient
+}
+
+func (suite *InterceptorTestSuite) SetupTest() {
+	suite.dispatcher = haystack.NewInMemoryDispatcher().(*haystack.InMemoryDispatcher)
+	suite.tracer, suite.closer = haystack.NewTracer("my-service", suite.dispatcher)
+
+	listener := bufconn.Listen(1024 * 1024)
+	suite.server = grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(suite.tracer)),
+		grpc.StreamInterceptor(StreamServerInterceptor(suite.tracer, InterceptorOptionsFactory.LogPayloads())))
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(suite.server, healthServer)
+	go func() {
+		_ = suite.server.Serve(listener)
+	}()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(suite.tracer)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(suite.tracer, InterceptorOptionsFactory.LogPayloads())))
+	suite.Require().NoError(err)
+	suite.conn = conn
+	suite.client = healthpb.NewHealthClient(conn)
+}
+
+func (suite *InterceptorTestSuite) TearDownTest() {
+	suite.NoError(suite.conn.Close())
+	suite.server.Stop()
+	suite.NoError(suite.closer.Close())
+}
+
+func (suite *InterceptorTestSuite) TestUnaryCallSharesSpanID() {
+	parent := suite.tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	_, err := suite.client.Check(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	suite.Require().NoError(err)
+	_, err = suite.client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	suite.Require().Error(err)
+	parent.Finish()
+
+	spans := suite.dispatcher.SpansByOperation("/grpc.health.v1.Health/Check")
+	suite.Require().Len(spans, 4)
+	server, client := haystack.NewSpanRecord(spans[0]), haystack.NewSpanRecord(spans[1])
+	suite.Equal(client.Context().(*haystack.SpanContext).SpanID, server.Context().(*haystack.SpanContext).SpanID, "server span should share the client span id")
+	suite.Equal(parent.Context().(*haystack.SpanContext).SpanID, client.Context().(*haystack.SpanContext).ParentID)
+	suite.assertTag(server, "span.kind", "server")
+	suite.assertTag(client, "span.kind", "client")
+	suite.assertTag(client, StatusCodeTagKey, "OK")
+
+	failedServer, failedClient := haystack.NewSpanRecord(spans[2]), haystack.NewSpanRecord(spans[3])
+	suite.assertTag(failedServer, StatusCodeTagKey, "NotFound")
+	suite.assertTag(failedClient, StatusCodeTagKey, "NotFound")
+	suite.assertTag(failedClient, "error", true)
+}
+
+func (suite *InterceptorTestSuite) TestStreamLogsMessages() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := suite.client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	suite.Require().NoError(err)
+	resp, err := stream.Recv()
+	suite.Require().NoError(err)
+	suite.Equal(healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	cancel()
+
+	spans, err := suite.dispatcher.WaitForSpans(2, 5*time.Second)
+	suite.Require().NoError(err)
+	for _, span := range spans {
+		record := haystack.NewSpanRecord(span)
+		suite.Equal("/grpc.health.v1.Health/Watch", record.OperationName())
+		suite.NotEmpty(record.Logs(), "stream messages should be logged")
+		suite.assertTag(record, StatusCodeTagKey, "Canceled")
+	}
+}
+
+func (suite *InterceptorTestSuite) assertTag(span *haystack.SpanRecord, key string, expected interface{}) {
+	value, ok := span.Tag(key)
+	suite.True(ok, "tag %s should be present", key)
+	suite.Equal(expected, value, "tag %s", key)
+}
+
+func TestUnitInterceptorSuite(t *testing.T) {
+	suite.Run(t, new(InterceptorTestSuite))
+}
@@ -0,0 +1,45 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackgrpc
+
+import (
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+/*MetadataCarrier adapts grpc metadata to opentracing.TextMapWriter and opentracing.TextMapReader, so it works with the tracer's TextMapPropagator. grpc lowercases the metadata keys*/
+type MetadataCarrier metadata.MD
+
+/*Set implements opentracing.TextMapWriter*/
+func (c MetadataCarrier) Set(key, val string) {
+	key = strings.ToLower(key)
+	c[key] = []string{val}
+}
+
+/*ForeachKey implements opentracing.TextMapReader*/
+func (c MetadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, vals := range c {
+		for _, v := range vals {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackhttp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	// RouteTagKey is the tag holding the matched route of the request
+	RouteTagKey = "http.route"
+
+	// ResponseSizeTagKey is the tag holding the number of bytes written in the response body
+	ResponseSizeTagKey = "http.response_size"
+
+	componentName = "net/http"
+)
+
+// MiddlewareOption is a function that sets some option on the middleware
+type MiddlewareOption func(opts *middlewareOptions)
+
+type middlewareOptions struct {
+	operationName func(r *http.Request) string
+	filter        func(r *http.Request) bool
+	route         func(r *http.Request) string
+}
+
+/*MiddlewareOptions a list of middleware options*/
+type MiddlewareOptions struct{}
+
+/*MiddlewareOptionsFactory factory to create multiple middleware options*/
+var MiddlewareOptionsFactory MiddlewareOptions
+
+/*OperationName sets the function naming the server span, defaults to "HTTP <method>"*/
+func (o MiddlewareOptions) OperationName(f func(r *http.Request) string) MiddlewareOption {
+	return func(opts *middlewareOptions) {
+		opts.operationName = f
+	}
+}
+
+/*Filter sets the function deciding whether the request is traced, e.g. to skip health checks*/
+func (o MiddlewareOptions) Filter(f func(r *http.Request) bool) MiddlewareOption {
+	return func(opts *middlewareOptions) {
+		opts.filter = f
+	}
+}
+
+/*Route sets the function returning the route template matched by the request, recorded as http.route*/
+func (o MiddlewareOptions) Route(f func(r *http.Request) string) MiddlewareOption {
+	return func(opts *middlewareOptions) {
+		opts.route = f
+	}
+}
+
+/*Middleware wraps the handler so that every request runs in a server span, which is available from the request context with opentracing.SpanFromContext*/
+func Middleware(tracer opentracing.Tracer, handler http.Handler, options ...MiddlewareOption) http.Handler {
+	opts := &middlewareOptions{
+		operationName: func(r *http.Request) string {
+			return "HTTP " + r.Method
+		},
+	}
+	for _, option := range options {
+		option(opts)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.filter != nil && !opts.filter(r) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		startOptions := []opentracing.StartSpanOption{
+			ext.SpanKindRPCServer,
+			opentracing.Tag{Key: string(ext.Component), Value: componentName},
+			opentracing.Tag{Key: string(ext.HTTPMethod), Value: r.Method},
+			opentracing.Tag{Key: string(ext.HTTPUrl), Value: r.URL.String()},
+		}
+		// the extracted context is shared by the server span in single span mode
+		if upstreamContext, err := tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header)); err == nil {
+			startOptions = append(startOptions, opentracing.ChildOf(upstreamContext))
+		}
+		if opts.route != nil {
+			if route := opts.route(r); route != "" {
+				startOptions = append(startOptions, opentracing.Tag{Key: RouteTagKey, Value: route})
+			}
+		}
+
+		span := tracer.StartSpan(opts.operationName(r), startOptions...)
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				ext.HTTPStatusCode.Set(span, http.StatusInternalServerError)
+				ext.Error.Set(span, true)
+				span.LogKV("event", "error", "message", fmt.Sprint(recovered))
+				span.Finish()
+				panic(recovered)
+			}
+
+			ext.HTTPStatusCode.Set(span, uint16(recorder.statusCode))
+			span.SetTag(ResponseSizeTagKey, recorder.size)
+			if recorder.statusCode >= http.StatusInternalServerError {
+				ext.Error.Set(span, true)
+			}
+			span.Finish()
+		}()
+
+		handler.ServeHTTP(recorder, r.WithContext(opentracing.ContextWithSpan(r.Context(), span)))
+	})
+}
+
+/*MiddlewareFunc is the http.HandlerFunc flavour of Middleware*/
+func MiddlewareFunc(tracer opentracing.Tracer, handler http.HandlerFunc, options ...MiddlewareOption) http.HandlerFunc {
+	return Middleware(tracer, handler, options...).ServeHTTP
+}
+
+// responseRecorder captures the status code and the size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	size        int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying writer does
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if the underlying writer does
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not implement http.Hijacker", r.ResponseWriter)
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,129 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type MiddlewareTestSuite struct {
+	suite.Suite
+	tracer     opentracing.Tracer
+	closer     io.Closer
+	dispatcher *haystack.InMemoryDispatcher
+}
+
+func (suite *MiddlewareTestSuite) SetupTest() {
+	suite.dispatcher = haystack.NewInMemoryDispatcher().(*haystack.InMemoryDispatcher)
+	suite.tracer, suite.closer = haystack.NewTracer("my-service", suite.dispatcher)
+}
+
+func (suite *MiddlewareTestSuite) TearDownTest() {
+	suite.Require().NoError(suite.closer.Close())
+}
+
+func (suite *MiddlewareTestSuite) TestServerSpanSharesUpstreamSpanID() {
+	var spanInContext opentracing.Span
+	handler := Middleware(suite.tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spanInContext = opentracing.SpanFromContext(r.Context())
+		_, _ = w.Write([]byte("hello"))
+	}), MiddlewareOptionsFactory.Route(func(r *http.Request) string { return "/users/{id}" }))
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1?q=x", nil)
+	req.Header.Set("Trace-ID", "T1")
+	req.Header.Set("Span-ID", "S1")
+	req.Header.Set("Parent-ID", "P1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	suite.NotNil(spanInContext, "server span should be stored in the request context")
+	spans := suite.dispatcher.Spans()
+	suite.Require().Len(spans, 1)
+	span := haystack.NewSpanRecord(spans[0])
+	ctx := span.Context().(*haystack.SpanContext)
+	suite.Equal("T1", ctx.TraceID)
+	suite.Equal("S1", ctx.SpanID, "single span mode should share the upstream span id")
+	suite.Equal("P1", ctx.ParentID)
+	suite.Equal("HTTP GET", span.OperationName())
+	suite.assertTag(span, "span.kind", "server")
+	suite.assertTag(span, "http.method", "GET")
+	suite.assertTag(span, "http.url", "/users/1?q=x")
+	suite.assertTag(span, RouteTagKey, "/users/{id}")
+	suite.assertTag(span, "http.status_code", int64(200))
+	suite.assertTag(span, ResponseSizeTagKey, int64(5))
+	_, hasError := span.Tag("error")
+	suite.False(hasError)
+}
+
+func (suite *MiddlewareTestSuite) TestServerErrorsAreTagged() {
+	handler := Middleware(suite.tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}), MiddlewareOptionsFactory.OperationName(func(r *http.Request) string { return r.URL.Path }))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	spans := suite.dispatcher.Spans()
+	suite.Require().Len(spans, 1)
+	span := haystack.NewSpanRecord(spans[0])
+	suite.Equal("/orders", span.OperationName())
+	suite.assertTag(span, "http.status_code", int64(503))
+	suite.assertTag(span, "error", true)
+}
+
+func (suite *MiddlewareTestSuite) TestPanicsFinishTheSpan() {
+	handler := Middleware(suite.tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	suite.Panics(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	spans := suite.dispatcher.Spans()
+	suite.Require().Len(spans, 1)
+	suite.assertTag(haystack.NewSpanRecord(spans[0]), "error", true)
+}
+
+func (suite *MiddlewareTestSuite) TestFilteredRequestsAreNotTraced() {
+	called := false
+	handler := MiddlewareFunc(suite.tracer, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		suite.Nil(opentracing.SpanFromContext(r.Context()))
+	}, MiddlewareOptionsFactory.Filter(func(r *http.Request) bool { return r.URL.Path != "/health" }))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	suite.True(called)
+	suite.Empty(suite.dispatcher.Spans())
+}
+
+func (suite *MiddlewareTestSuite) assertTag(span *haystack.SpanRecord, key string, expected interface{}) {
+	value, ok := span.Tag(key)
+	suite.True(ok, "tag %s should be present", key)
+	suite.Equal(expected, value, "tag %s", key)
+}
+
+func TestUnitMiddlewareSuite(t *testing.T) {
+	suite.Run(t, new(MiddlewareTestSuite))
+}
@@ -0,0 +1,294 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackhttp
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// TransportOption is a function that sets some option on the transport
+type TransportOption func(opts *transportOptions)
+
+type transportOptions struct {
+	operationName func(r *http.Request) string
+	clientTrace   bool
+}
+
+/*TransportOptions a list of transport options*/
+type TransportOptions struct{}
+
+/*TransportOptionsFactory factory to create multiple transport options*/
+var TransportOptionsFactory TransportOptions
+
+/*OperationName sets the function naming the client span, defaults to "HTTP <method>"*/
+func (o TransportOptions) OperationName(f func(r *http.Request) string) TransportOption {
+	return func(opts *transportOptions) {
+		opts.operationName = f
+	}
+}
+
+/*ClientTrace creates sub-spans of the client span for dns lookup, connect, tls handshake and time to first byte*/
+func (o TransportOptions) ClientTrace() TransportOption {
+	return func(opts *transportOptions) {
+		opts.clientTrace = true
+	}
+}
+
+/*Transport is a http.RoundTripper that runs every request in a client span and propagates its context in the request headers*/
+type Transport struct {
+	tracer opentracing.Tracer
+	base   http.RoundTripper
+	opts   transportOptions
+}
+
+/*NewTransport wraps the base round tripper, http.DefaultTransport is used if base is nil*/
+func NewTransport(tracer opentracing.Tracer, base http.RoundTripper, options ...TransportOption) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport := &Transport{
+		tracer: tracer,
+		base:   base,
+		opts: transportOptions{
+			operationName: func(r *http.Request) string {
+				return "HTTP " + r.Method
+			},
+		},
+	}
+	for _, option := range options {
+		option(&transport.opts)
+	}
+	return transport
+}
+
+/*RoundTrip implements http.RoundTripper, the client span is a child of the span in the request context and finishes once the response body is read or closed*/
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	startOptions := []opentracing.StartSpanOption{
+		ext.SpanKindRPCClient,
+		opentracing.Tag{Key: string(ext.Component), Value: componentName},
+		opentracing.Tag{Key: string(ext.HTTPMethod), Value: req.Method},
+		opentracing.Tag{Key: string(ext.HTTPUrl), Value: req.URL.String()},
+	}
+	if parent := opentracing.SpanFromContext(req.Context()); parent != nil {
+		startOptions = append(startOptions, opentracing.ChildOf(parent.Context()))
+	}
+	span := t.tracer.StartSpan(t.opts.operationName(req), startOptions...)
+	setPeer(span, req.URL.Host)
+
+	// a round tripper must not modify the caller's request
+	ctx := opentracing.ContextWithSpan(req.Context(), span)
+	if t.opts.clientTrace {
+		ctx = httptrace.WithClientTrace(ctx, newClientTracer(t.tracer, span).clientTrace())
+	}
+	outgoing := req.Clone(ctx)
+
+	if err := t.tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(outgoing.Header)); err != nil {
+		span.LogFields(log.String("event", "inject-failed"), log.String("message", err.Error()))
+	}
+
+	resp, err := t.base.RoundTrip(outgoing)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "error"), log.String("error.kind", "transport"), log.String("message", err.Error()))
+		span.Finish()
+		return nil, err
+	}
+
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		ext.Error.Set(span, true)
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody || req.Method == http.MethodHead {
+		span.Finish()
+		return resp, nil
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+func setPeer(span opentracing.Span, hostPort string) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		ext.PeerHostname.Set(span, hostPort)
+		return
+	}
+	ext.PeerHostname.Set(span, host)
+	if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+		ext.PeerPort.Set(span, uint16(p))
+	}
+}
+
+// spanBody finishes the client span once the response body is consumed or closed
+type spanBody struct {
+	io.ReadCloser
+	span opentracing.Span
+	once sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finish()
+	} else if err != nil {
+		b.span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *spanBody) finish() {
+	b.once.Do(b.span.Finish)
+}
+
+// clientTracer turns the httptrace callbacks into sub-spans of the client span
+type clientTracer struct {
+	tracer opentracing.Tracer
+	parent opentracing.Span
+
+	mu       sync.Mutex
+	dns      opentracing.Span
+	connects map[string]opentracing.Span
+	tls      opentracing.Span
+	ttfb     opentracing.Span
+}
+
+func newClientTracer(tracer opentracing.Tracer, parent opentracing.Span) *clientTracer {
+	return &clientTracer{
+		tracer:   tracer,
+		parent:   parent,
+		connects: make(map[string]opentracing.Span),
+	}
+}
+
+func (c *clientTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             c.dnsStart,
+		DNSDone:              c.dnsDone,
+		ConnectStart:         c.connectStart,
+		ConnectDone:          c.connectDone,
+		TLSHandshakeStart:    c.tlsHandshakeStart,
+		TLSHandshakeDone:     c.tlsHandshakeDone,
+		WroteRequest:         c.wroteRequest,
+		GotFirstResponseByte: c.gotFirstResponseByte,
+	}
+}
+
+func (c *clientTracer) startSpan(operationName string) opentracing.Span {
+	return c.tracer.StartSpan(operationName,
+		opentracing.ChildOf(c.parent.Context()),
+		opentracing.Tag{Key: string(ext.Component), Value: componentName})
+}
+
+func finishWithError(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+	}
+	span.Finish()
+}
+
+func (c *clientTracer) dnsStart(info httptrace.DNSStartInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dns = c.startSpan("DNS")
+	c.dns.SetTag("dns.host", info.Host)
+}
+
+func (c *clientTracer) dnsDone(info httptrace.DNSDoneInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dns == nil {
+		return
+	}
+	c.dns.SetTag("dns.coalesced", info.Coalesced)
+	finishWithError(c.dns, info.Err)
+	c.dns = nil
+}
+
+func (c *clientTracer) connectStart(network, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	span := c.startSpan("Connect")
+	span.SetTag("net.network", network)
+	setPeer(span, addr)
+	c.connects[network+addr] = span
+}
+
+func (c *clientTracer) connectDone(network, addr string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if span, ok := c.connects[network+addr]; ok {
+		finishWithError(span, err)
+		delete(c.connects, network+addr)
+	}
+}
+
+func (c *clientTracer) tlsHandshakeStart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tls = c.startSpan("TLS")
+}
+
+func (c *clientTracer) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tls == nil {
+		return
+	}
+	if err == nil {
+		c.tls.SetTag("tls.resumed", state.DidResume)
+	}
+	finishWithError(c.tls, err)
+	c.tls = nil
+}
+
+func (c *clientTracer) wroteRequest(info httptrace.WroteRequestInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if info.Err != nil {
+		return
+	}
+	c.ttfb = c.startSpan("TTFB")
+}
+
+func (c *clientTracer) gotFirstResponseByte() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttfb == nil {
+		return
+	}
+	c.ttfb.Finish()
+	c.ttfb = nil
+}
@@ -0,0 +1,152 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackhttp
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type TransportTestSuite struct {
+	suite.Suite
+	dispatcher *haystack.InMemoryDispatcher
+	baggage    string
+}
+
+func (suite *TransportTestSuite) SetupTest() {
+	suite.dispatcher = haystack.NewInMemoryDispatcher().(*haystack.InMemoryDispatcher)
+	suite.baggage = ""
+}
+
+func (suite *TransportTestSuite) newServer(tracer opentracing.Tracer) *httptest.Server {
+	return httptest.NewServer(Middleware(tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.baggage = opentracing.SpanFromContext(r.Context()).BaggageItem("user")
+		_, _ = w.Write([]byte("ok"))
+	}), MiddlewareOptionsFactory.OperationName(func(r *http.Request) string { return "server" })))
+}
+
+func (suite *TransportTestSuite) call(tracer opentracing.Tracer, url string, options ...TransportOption) {
+	parent := tracer.StartSpan("parent")
+	parent.SetBaggageItem("user", "jane doe&co=1")
+
+	client := &http.Client{Transport: NewTransport(tracer, nil, options...)}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	suite.Require().NoError(err)
+	resp, err := client.Do(req.WithContext(opentracing.ContextWithSpan(context.Background(), parent)))
+	suite.Require().NoError(err)
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	suite.Require().NoError(err)
+	suite.Require().NoError(resp.Body.Close())
+	parent.Finish()
+}
+
+func (suite *TransportTestSuite) TestSingleSpanMode() {
+	tracer, closer := haystack.NewTracer("my-service", suite.dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	server := suite.newServer(tracer)
+	defer server.Close()
+
+	suite.call(tracer, server.URL)
+
+	parent := suite.dispatcher.SpansByOperation("parent")[0]
+	client := suite.dispatcher.SpansByOperation("HTTP GET")[0]
+	serverSpan := suite.dispatcher.SpansByOperation("server")[0]
+	suite.Equal(parent.GetSpanId(), client.GetParentSpanId())
+	suite.Equal(client.GetSpanId(), serverSpan.GetSpanId(), "server span should share the client span id")
+	suite.Equal("jane doe&co=1", suite.baggage, "baggage should survive the url encoding")
+
+	record := haystack.NewSpanRecord(client)
+	status, _ := record.Tag("http.status_code")
+	suite.Equal(int64(200), status)
+	host, _ := record.Tag("peer.hostname")
+	suite.Equal("127.0.0.1", host)
+	kind, _ := record.Tag("span.kind")
+	suite.Equal("client", kind)
+}
+
+func (suite *TransportTestSuite) TestDualSpanMode() {
+	tracer, closer := haystack.NewTracer("my-service", suite.dispatcher, haystack.TracerOptionsFactory.UseDualSpanMode())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	server := suite.newServer(tracer)
+	defer server.Close()
+
+	suite.call(tracer, server.URL, TransportOptionsFactory.ClientTrace())
+
+	client := suite.dispatcher.SpansByOperation("HTTP GET")[0]
+	serverSpan := suite.dispatcher.SpansByOperation("server")[0]
+	suite.Equal(client.GetSpanId(), serverSpan.GetParentSpanId())
+	suite.NotEqual(client.GetSpanId(), serverSpan.GetSpanId())
+
+	suite.Len(suite.dispatcher.SpansByOperation("Connect"), 1)
+	suite.Len(suite.dispatcher.SpansByOperation("TTFB"), 1)
+	for _, sub := range suite.dispatcher.ChildrenOf(client.GetSpanId()) {
+		suite.Contains([]string{"Connect", "TTFB", "server"}, sub.GetOperationName())
+	}
+}
+
+func (suite *TransportTestSuite) TestClientTraceUsesTheTracerClock() {
+	now := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	tracer, closer := haystack.NewTracer("my-service", suite.dispatcher,
+		haystack.TracerOptionsFactory.Clock(haystack.ClockFunc(func() time.Time { return now })))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	server := suite.newServer(tracer)
+	defer server.Close()
+
+	suite.call(tracer, server.URL, TransportOptionsFactory.ClientTrace())
+
+	connect := suite.dispatcher.SpansByOperation("Connect")
+	suite.Require().Len(connect, 1)
+	suite.Equal(now.UnixNano()/int64(time.Microsecond), connect[0].GetStartTime())
+}
+
+func (suite *TransportTestSuite) TestTransportErrorsAreTagged() {
+	tracer, closer := haystack.NewTracer("my-service", suite.dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	server := suite.newServer(tracer)
+	server.Close()
+
+	client := &http.Client{Transport: NewTransport(tracer, nil)}
+	_, err := client.Get(server.URL)
+	suite.Error(err)
+
+	spans := suite.dispatcher.Spans()
+	suite.Require().Len(spans, 1)
+	value, _ := haystack.NewSpanRecord(spans[0]).Tag("error")
+	suite.Equal(true, value)
+}
+
+func TestUnitTransportSuite(t *testing.T) {
+	suite.Run(t, new(TransportTestSuite))
+}
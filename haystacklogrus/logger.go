@@ -0,0 +1,63 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacklogrus
+
+import (
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+)
+
+/*Logger adapts a logrus logger or entry to haystack.Logger*/
+type Logger struct {
+	logger logrus.FieldLogger
+}
+
+/*NewLogger creates a logger writing to the given logrus logger or entry*/
+func NewLogger(logger logrus.FieldLogger) *Logger {
+	return &Logger{logger: logger}
+}
+
+/*Error prints the error message*/
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.logger.Errorf(format, v...)
+}
+
+/*Info prints the info message*/
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.logger.Infof(format, v...)
+}
+
+/*Debug prints the debug message*/
+func (l *Logger) Debug(format string, v ...interface{}) {
+	l.logger.Debugf(format, v...)
+}
+
+/*TraceFields returns the traceId and spanId fields of a haystack span context, or empty fields for any other span context*/
+func TraceFields(spanContext opentracing.SpanContext) logrus.Fields {
+	traceID, spanID, ok := haystack.TraceIDs(spanContext)
+	if !ok {
+		return logrus.Fields{}
+	}
+	return logrus.Fields{haystack.TraceIDLogField: traceID, haystack.SpanIDLogField: spanID}
+}
+
+/*WithSpan returns an entry of the logger carrying the traceId and spanId of the span*/
+func WithSpan(logger logrus.FieldLogger, span opentracing.Span) *logrus.Entry {
+	return logger.WithFields(TraceFields(span.Context()))
+}
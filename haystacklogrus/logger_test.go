@@ -0,0 +1,70 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacklogrus
+
+import (
+	"io"
+	"testing"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggerTestSuite struct {
+	suite.Suite
+	logger *logrus.Logger
+	hook   *test.Hook
+}
+
+func (suite *LoggerTestSuite) SetupTest() {
+	suite.logger, suite.hook = test.NewNullLogger()
+	suite.logger.SetOutput(io.Discard)
+	suite.logger.SetLevel(logrus.DebugLevel)
+}
+
+func (suite *LoggerTestSuite) TestLoggerLevels() {
+	logger := NewLogger(suite.logger)
+	logger.Info("dispatched %d spans", 3)
+	logger.Error("fail to dispatch: %s", "timeout")
+	logger.Debug("queue is empty")
+
+	entries := suite.hook.AllEntries()
+	suite.Require().Len(entries, 3)
+	suite.Equal(logrus.InfoLevel, entries[0].Level)
+	suite.Equal("dispatched 3 spans", entries[0].Message)
+	suite.Equal(logrus.ErrorLevel, entries[1].Level)
+	suite.Equal(logrus.DebugLevel, entries[2].Level)
+}
+
+func (suite *LoggerTestSuite) TestWithSpan() {
+	tracer, closer := haystack.NewTracer("my-service", haystack.NewInMemoryDispatcher())
+	defer closer.Close()
+	span := tracer.StartSpan("op")
+
+	WithSpan(suite.logger, span).Info("order paid")
+
+	entry := suite.hook.LastEntry()
+	suite.Equal(span.Context().(*haystack.SpanContext).TraceID, entry.Data[haystack.TraceIDLogField])
+	suite.Equal(span.Context().(*haystack.SpanContext).SpanID, entry.Data[haystack.SpanIDLogField])
+}
+
+func TestUnitLoggerSuite(t *testing.T) {
+	suite.Run(t, new(LoggerTestSuite))
+}
@@ -0,0 +1,101 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackprometheus
+
+import (
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "haystack_client"
+
+/*Collector is a prometheus.Collector exposing the Stats of a haystack tracer*/
+type Collector struct {
+	reporter haystack.StatsReporter
+
+	spansStarted   *prometheus.Desc
+	spansFinished  *prometheus.Desc
+	spansDetached  *prometheus.Desc
+	spansEnqueued  *prometheus.Desc
+	spansSent      *prometheus.Desc
+	spansFailed    *prometheus.Desc
+	spansDropped   *prometheus.Desc
+	queueDepth     *prometheus.Desc
+	queueCapacity  *prometheus.Desc
+	sendLatency    *prometheus.Desc
+	sendLatencyMax *prometheus.Desc
+}
+
+/*NewCollector creates a collector reading the stats of the reporter, usually the *haystack.Tracer, on every scrape. The constant labels tell apart the tracers of a process*/
+func NewCollector(reporter haystack.StatsReporter, constLabels prometheus.Labels) *Collector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, constLabels)
+	}
+	return &Collector{
+		reporter:       reporter,
+		spansStarted:   desc("spans_started_total", "Number of spans started by the tracer."),
+		spansFinished:  desc("spans_finished_total", "Number of spans finished and handed to the dispatcher."),
+		spansDetached:  desc("spans_detached_total", "Number of spans detached to a span handle."),
+		spansEnqueued:  desc("spans_enqueued_total", "Number of spans queued by the dispatcher for sending."),
+		spansSent:      desc("spans_sent_total", "Number of spans successfully sent by the dispatcher."),
+		spansFailed:    desc("spans_failed_total", "Number of spans the dispatcher failed to send."),
+		spansDropped:   desc("spans_dropped_total", "Number of spans dropped because the dispatcher queue was full."),
+		queueDepth:     desc("queue_depth", "Number of spans waiting in the dispatcher queue."),
+		queueCapacity:  desc("queue_capacity", "Maximum number of spans the dispatcher queue can hold."),
+		sendLatency:    desc("send_latency_seconds", "Time spent sending spans, successfully or not."),
+		sendLatencyMax: desc("send_latency_max_seconds", "Longest time spent sending a span."),
+	}
+}
+
+/*Describe implements prometheus.Collector*/
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.spansStarted
+	ch <- c.spansFinished
+	ch <- c.spansDetached
+	ch <- c.spansEnqueued
+	ch <- c.spansSent
+	ch <- c.spansFailed
+	ch <- c.spansDropped
+	ch <- c.queueDepth
+	ch <- c.queueCapacity
+	ch <- c.sendLatency
+	ch <- c.sendLatencyMax
+}
+
+/*Collect implements prometheus.Collector*/
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.reporter.Stats()
+	counter := func(desc *prometheus.Desc, value int64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value))
+	}
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+
+	counter(c.spansStarted, stats.SpansStarted)
+	counter(c.spansFinished, stats.SpansFinished)
+	counter(c.spansDetached, stats.SpansDetached)
+	counter(c.spansEnqueued, stats.SpansEnqueued)
+	counter(c.spansSent, stats.SpansSent)
+	counter(c.spansFailed, stats.SpansFailed)
+	counter(c.spansDropped, stats.SpansDropped)
+	gauge(c.queueDepth, float64(stats.QueueDepth))
+	gauge(c.queueCapacity, float64(stats.QueueCapacity))
+	ch <- prometheus.MustNewConstSummary(c.sendLatency, uint64(stats.SpansSent+stats.SpansFailed), stats.SendLatencySum.Seconds(), nil)
+	gauge(c.sendLatencyMax, stats.SendLatencyMax.Seconds())
+}
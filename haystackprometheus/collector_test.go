@@ -0,0 +1,73 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackprometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type staticReporter haystack.Stats
+
+func (r staticReporter) Stats() haystack.Stats { return haystack.Stats(r) }
+
+type CollectorTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CollectorTestSuite) TestCollect() {
+	collector := NewCollector(staticReporter{
+		SpansStarted:   10,
+		SpansFinished:  9,
+		SpansDetached:  1,
+		SpansEnqueued:  8,
+		SpansSent:      6,
+		SpansFailed:    2,
+		SpansDropped:   1,
+		QueueDepth:     3,
+		QueueCapacity:  1000,
+		SendLatencySum: 4 * time.Second,
+		SendLatencyMax: 2 * time.Second,
+	}, prometheus.Labels{"service": "orders"})
+
+	expected := `
+# HELP haystack_client_spans_dropped_total Number of spans dropped because the dispatcher queue was full.
+# TYPE haystack_client_spans_dropped_total counter
+haystack_client_spans_dropped_total{service="orders"} 1
+# HELP haystack_client_queue_depth Number of spans waiting in the dispatcher queue.
+# TYPE haystack_client_queue_depth gauge
+haystack_client_queue_depth{service="orders"} 3
+# HELP haystack_client_send_latency_seconds Time spent sending spans, successfully or not.
+# TYPE haystack_client_send_latency_seconds summary
+haystack_client_send_latency_seconds_sum{service="orders"} 4
+haystack_client_send_latency_seconds_count{service="orders"} 8
+`
+	suite.NoError(testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"haystack_client_spans_dropped_total", "haystack_client_queue_depth", "haystack_client_send_latency_seconds"))
+	suite.Equal(11, testutil.CollectAndCount(collector))
+}
+
+func TestUnitCollectorSuite(t *testing.T) {
+	suite.Run(t, new(CollectorTestSuite))
+}
@@ -0,0 +1,56 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackprometheus
+
+import (
+	"strconv"
+	"time"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*REDSink is a haystack.MetricsSink recording the span durations in a prometheus histogram labelled by service, operation, kind and error. It is a prometheus.Collector to be registered*/
+type REDSink struct {
+	*prometheus.HistogramVec
+}
+
+/*NewREDSink creates the sink, the buckets are the haystack.DefaultREDBuckets if none is given*/
+func NewREDSink(buckets []time.Duration, constLabels prometheus.Labels) *REDSink {
+	if len(buckets) == 0 {
+		buckets = haystack.DefaultREDBuckets
+	}
+	seconds := make([]float64, len(buckets))
+	for i, bucket := range buckets {
+		seconds[i] = bucket.Seconds()
+	}
+	return &REDSink{
+		HistogramVec: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "span_duration_seconds",
+			Help:        "Duration of the finished spans.",
+			Buckets:     seconds,
+			ConstLabels: constLabels,
+		}, []string{"service", "operation", "kind", "error"}),
+	}
+}
+
+/*Observe implements haystack.MetricsSink*/
+func (s *REDSink) Observe(key haystack.REDKey, duration time.Duration) {
+	s.WithLabelValues(key.ServiceName, key.OperationName, key.SpanKind, strconv.FormatBool(key.Error)).Observe(duration.Seconds())
+}
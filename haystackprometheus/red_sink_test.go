@@ -0,0 +1,53 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackprometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type REDSinkTestSuite struct {
+	suite.Suite
+}
+
+func (suite *REDSinkTestSuite) TestObserve() {
+	sink := NewREDSink([]time.Duration{100 * time.Millisecond}, nil)
+	key := haystack.REDKey{ServiceName: "my-service", OperationName: "GET /orders", SpanKind: "server"}
+	sink.Observe(key, 50*time.Millisecond)
+	sink.Observe(key, 250*time.Millisecond)
+
+	expected := `
+# HELP haystack_client_span_duration_seconds Duration of the finished spans.
+# TYPE haystack_client_span_duration_seconds histogram
+haystack_client_span_duration_seconds_bucket{error="false",kind="server",operation="GET /orders",service="my-service",le="0.1"} 1
+haystack_client_span_duration_seconds_bucket{error="false",kind="server",operation="GET /orders",service="my-service",le="+Inf"} 2
+haystack_client_span_duration_seconds_sum{error="false",kind="server",operation="GET /orders",service="my-service"} 0.3
+haystack_client_span_duration_seconds_count{error="false",kind="server",operation="GET /orders",service="my-service"} 2
+`
+	suite.NoError(testutil.CollectAndCompare(sink, strings.NewReader(expected)))
+}
+
+func TestUnitREDSinkSuite(t *testing.T) {
+	suite.Run(t, new(REDSinkTestSuite))
+}
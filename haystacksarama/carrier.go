@@ -0,0 +1,77 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacksarama
+
+import (
+	"github.com/Shopify/sarama"
+)
+
+/*ProducerMessageCarrier adapts the record headers of a message being produced to opentracing.TextMapWriter and opentracing.TextMapReader, so it works with the tracer's TextMapPropagator*/
+type ProducerMessageCarrier struct {
+	Message *sarama.ProducerMessage
+}
+
+/*Set implements opentracing.TextMapWriter, an existing header with the same key is replaced*/
+func (c ProducerMessageCarrier) Set(key, val string) {
+	for i := range c.Message.Headers {
+		if string(c.Message.Headers[i].Key) == key {
+			c.Message.Headers[i].Value = []byte(val)
+			return
+		}
+	}
+	c.Message.Headers = append(c.Message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(val)})
+}
+
+/*ForeachKey implements opentracing.TextMapReader*/
+func (c ProducerMessageCarrier) ForeachKey(handler func(key, val string) error) error {
+	for _, header := range c.Message.Headers {
+		if err := handler(string(header.Key), string(header.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*ConsumerMessageCarrier adapts the record headers of a consumed message to opentracing.TextMapReader and opentracing.TextMapWriter, so it works with the tracer's TextMapPropagator*/
+type ConsumerMessageCarrier struct {
+	Message *sarama.ConsumerMessage
+}
+
+/*Set implements opentracing.TextMapWriter, an existing header with the same key is replaced*/
+func (c ConsumerMessageCarrier) Set(key, val string) {
+	for _, header := range c.Message.Headers {
+		if header != nil && string(header.Key) == key {
+			header.Value = []byte(val)
+			return
+		}
+	}
+	c.Message.Headers = append(c.Message.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(val)})
+}
+
+/*ForeachKey implements opentracing.TextMapReader*/
+func (c ConsumerMessageCarrier) ForeachKey(handler func(key, val string) error) error {
+	for _, header := range c.Message.Headers {
+		if header == nil {
+			continue
+		}
+		if err := handler(string(header.Key), string(header.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
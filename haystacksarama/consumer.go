@@ -0,0 +1,92 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacksarama
+
+import (
+	"context"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+/*MessageHandler processes a consumed message, the consumer span is available from the context with opentracing.SpanFromContext*/
+type MessageHandler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+
+/*StartConsumerSpan starts a consumer span for the message, following from the producer span propagated in its record headers*/
+func StartConsumerSpan(tracer opentracing.Tracer, msg *sarama.ConsumerMessage) opentracing.Span {
+	startOptions := []opentracing.StartSpanOption{
+		ext.SpanKindConsumer,
+		opentracing.Tag{Key: string(ext.Component), Value: componentName},
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: msg.Topic},
+		opentracing.Tag{Key: PartitionTagKey, Value: msg.Partition},
+		opentracing.Tag{Key: OffsetTagKey, Value: msg.Offset},
+	}
+	if producerContext, err := tracer.Extract(opentracing.TextMap, ConsumerMessageCarrier{Message: msg}); err == nil {
+		// the consumer must not share the span id of the producer in single span mode
+		if spanContext, ok := producerContext.(*haystack.SpanContext); ok {
+			spanContext.IsExtractedContext = false
+		}
+		startOptions = append(startOptions, opentracing.FollowsFrom(producerContext))
+	}
+	return tracer.StartSpan(ConsumeOperationName, startOptions...)
+}
+
+/*TraceMessageHandler wraps the handler so that every message is processed in a consumer span*/
+func TraceMessageHandler(tracer opentracing.Tracer, handler MessageHandler) MessageHandler {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		span := StartConsumerSpan(tracer, msg)
+		defer span.Finish()
+
+		err := handler(opentracing.ContextWithSpan(ctx, span), msg)
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+		}
+		return err
+	}
+}
+
+/*NewConsumerGroupHandler returns a sarama.ConsumerGroupHandler processing every claimed message in a consumer span. A message is marked once the handler succeeds, an error of the handler ends the claim*/
+func NewConsumerGroupHandler(tracer opentracing.Tracer, handler MessageHandler) sarama.ConsumerGroupHandler {
+	return &consumerGroupHandler{handler: TraceMessageHandler(tracer, handler)}
+}
+
+type consumerGroupHandler struct {
+	handler MessageHandler
+}
+
+func (h *consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if err := h.handler(session.Context(), msg); err != nil {
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacksarama
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	// PartitionTagKey is the tag holding the partition of the message
+	PartitionTagKey = "kafka.partition"
+
+	// OffsetTagKey is the tag holding the offset of the message
+	OffsetTagKey = "kafka.offset"
+
+	// ProduceOperationName is the operation name of the producer spans
+	ProduceOperationName = "kafka.produce"
+
+	// ConsumeOperationName is the operation name of the consumer spans
+	ConsumeOperationName = "kafka.consume"
+
+	componentName = "sarama"
+)
+
+/*SyncProducer is a sarama.SyncProducer that sends every message in a producer span and propagates its context in the record headers*/
+type SyncProducer struct {
+	sarama.SyncProducer
+	tracer opentracing.Tracer
+}
+
+/*WrapSyncProducer wraps the producer, the other methods of the producer are passed through*/
+func WrapSyncProducer(tracer opentracing.Tracer, producer sarama.SyncProducer) *SyncProducer {
+	return &SyncProducer{SyncProducer: producer, tracer: tracer}
+}
+
+/*SendMessage implements sarama.SyncProducer, the producer span starts a new trace*/
+func (p *SyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	return p.SendMessageContext(context.Background(), msg)
+}
+
+/*SendMessageContext sends the message in a producer span that is a child of the span in the context*/
+func (p *SyncProducer) SendMessageContext(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	span := startProducerSpan(ctx, p.tracer, msg)
+	partition, offset, err := p.SyncProducer.SendMessage(msg)
+	finishProducerSpan(span, msg, err)
+	return partition, offset, err
+}
+
+/*SendMessages implements sarama.SyncProducer, every producer span starts a new trace*/
+func (p *SyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	return p.SendMessagesContext(context.Background(), msgs)
+}
+
+/*SendMessagesContext sends each message in its own producer span that is a child of the span in the context*/
+func (p *SyncProducer) SendMessagesContext(ctx context.Context, msgs []*sarama.ProducerMessage) error {
+	spans := make([]opentracing.Span, len(msgs))
+	for i, msg := range msgs {
+		spans[i] = startProducerSpan(ctx, p.tracer, msg)
+	}
+
+	err := p.SyncProducer.SendMessages(msgs)
+
+	failed := make(map[*sarama.ProducerMessage]error)
+	if producerErrors, ok := err.(sarama.ProducerErrors); ok {
+		for _, producerError := range producerErrors {
+			failed[producerError.Msg] = producerError.Err
+		}
+	} else if err != nil {
+		for _, msg := range msgs {
+			failed[msg] = err
+		}
+	}
+	for i, msg := range msgs {
+		finishProducerSpan(spans[i], msg, failed[msg])
+	}
+	return err
+}
+
+/*AsyncProducer is a sarama.AsyncProducer that runs every message in a producer span and propagates its context in the record headers. The span finishes once the message is acknowledged if Producer.Return.Successes is set, otherwise once it is handed to the producer*/
+type AsyncProducer struct {
+	sarama.AsyncProducer
+	tracer          opentracing.Tracer
+	returnSuccesses bool
+
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	spans map[*sarama.ProducerMessage]opentracing.Span
+}
+
+/*WrapAsyncProducer wraps the producer created with the given config, sarama's default config is assumed if it is nil. The successes and errors must be read from the wrapper, the same way they are read from the producer*/
+func WrapAsyncProducer(tracer opentracing.Tracer, producer sarama.AsyncProducer, config *sarama.Config) *AsyncProducer {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+	p := &AsyncProducer{
+		AsyncProducer:   producer,
+		tracer:          tracer,
+		returnSuccesses: config.Producer.Return.Successes,
+		input:           make(chan *sarama.ProducerMessage),
+		successes:       make(chan *sarama.ProducerMessage),
+		errors:          make(chan *sarama.ProducerError),
+		spans:           make(map[*sarama.ProducerMessage]opentracing.Span),
+	}
+	go p.dispatchInput()
+	go p.dispatchSuccesses()
+	go p.dispatchErrors()
+	return p
+}
+
+/*Input implements sarama.AsyncProducer, the producer span of a message sent to this channel starts a new trace*/
+func (p *AsyncProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+/*InputContext sends the message in a producer span that is a child of the span in the context*/
+func (p *AsyncProducer) InputContext(ctx context.Context, msg *sarama.ProducerMessage) {
+	span := startProducerSpan(ctx, p.tracer, msg)
+	p.mu.Lock()
+	p.spans[msg] = span
+	p.mu.Unlock()
+	p.input <- msg
+}
+
+/*Successes implements sarama.AsyncProducer*/
+func (p *AsyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+/*Errors implements sarama.AsyncProducer*/
+func (p *AsyncProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+/*AsyncClose implements sarama.AsyncProducer*/
+func (p *AsyncProducer) AsyncClose() {
+	p.closeOnce.Do(func() {
+		close(p.input)
+	})
+}
+
+/*Close implements sarama.AsyncProducer, it drains the successes and returns the errors like sarama does*/
+func (p *AsyncProducer) Close() error {
+	p.AsyncClose()
+	go func() {
+		for range p.successes {
+		}
+	}()
+
+	var producerErrors sarama.ProducerErrors
+	for producerError := range p.errors {
+		producerErrors = append(producerErrors, producerError)
+	}
+	if len(producerErrors) > 0 {
+		return producerErrors
+	}
+	return nil
+}
+
+func (p *AsyncProducer) dispatchInput() {
+	for msg := range p.input {
+		p.mu.Lock()
+		span, ok := p.spans[msg]
+		if !ok {
+			span = startProducerSpan(context.Background(), p.tracer, msg)
+			p.spans[msg] = span
+		}
+		if !p.returnSuccesses {
+			delete(p.spans, msg)
+		}
+		p.mu.Unlock()
+
+		p.AsyncProducer.Input() <- msg
+		if !p.returnSuccesses {
+			span.Finish()
+		}
+	}
+	p.AsyncProducer.AsyncClose()
+}
+
+func (p *AsyncProducer) dispatchSuccesses() {
+	for msg := range p.AsyncProducer.Successes() {
+		if span := p.removeSpan(msg); span != nil {
+			finishProducerSpan(span, msg, nil)
+		}
+		p.successes <- msg
+	}
+	close(p.successes)
+}
+
+func (p *AsyncProducer) dispatchErrors() {
+	for producerError := range p.AsyncProducer.Errors() {
+		if span := p.removeSpan(producerError.Msg); span != nil {
+			finishProducerSpan(span, producerError.Msg, producerError.Err)
+		}
+		p.errors <- producerError
+	}
+	close(p.errors)
+}
+
+func (p *AsyncProducer) removeSpan(msg *sarama.ProducerMessage) opentracing.Span {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	span, ok := p.spans[msg]
+	if !ok {
+		return nil
+	}
+	delete(p.spans, msg)
+	return span
+}
+
+func startProducerSpan(ctx context.Context, tracer opentracing.Tracer, msg *sarama.ProducerMessage) opentracing.Span {
+	startOptions := []opentracing.StartSpanOption{
+		ext.SpanKindProducer,
+		opentracing.Tag{Key: string(ext.Component), Value: componentName},
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: msg.Topic},
+	}
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		startOptions = append(startOptions, opentracing.ChildOf(parent.Context()))
+	}
+	span := tracer.StartSpan(ProduceOperationName, startOptions...)
+	if err := tracer.Inject(span.Context(), opentracing.TextMap, ProducerMessageCarrier{Message: msg}); err != nil {
+		span.LogFields(log.String("event", "inject-failed"), log.String("message", err.Error()))
+	}
+	return span
+}
+
+func finishProducerSpan(span opentracing.Span, msg *sarama.ProducerMessage, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+	} else {
+		span.SetTag(PartitionTagKey, msg.Partition)
+		span.SetTag(OffsetTagKey, msg.Offset)
+	}
+	span.Finish()
+}
@@ -0,0 +1,222 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacksarama
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeSession is a consumer group session recording the marked messages
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	marked []*sarama.ConsumerMessage
+}
+
+func (s *fakeSession) Context() context.Context { return context.Background() }
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg)
+}
+
+// fakeClaim is a consumer group claim over a fixed list of messages
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func newFakeClaim(msgs ...*sarama.ConsumerMessage) *fakeClaim {
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(msgs))}
+	for _, msg := range msgs {
+		claim.messages <- msg
+	}
+	close(claim.messages)
+	return claim
+}
+
+// failingProducer fails every message but the first one
+type failingProducer struct {
+	sarama.SyncProducer
+}
+
+func (p failingProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	msgs[0].Offset = 1
+	var producerErrors sarama.ProducerErrors
+	for _, msg := range msgs[1:] {
+		producerErrors = append(producerErrors, &sarama.ProducerError{Msg: msg, Err: sarama.ErrOutOfBrokers})
+	}
+	return producerErrors
+}
+
+// consumed turns a produced message into the message a consumer would receive
+func consumed(msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	headers := make([]*sarama.RecordHeader, len(msg.Headers))
+	for i := range msg.Headers {
+		headers[i] = &msg.Headers[i]
+	}
+	return &sarama.ConsumerMessage{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset, Headers: headers}
+}
+
+type SaramaTestSuite struct {
+	suite.Suite
+	dispatcher *haystack.InMemoryDispatcher
+	tracer     opentracing.Tracer
+	closer     io.Closer
+}
+
+func (suite *SaramaTestSuite) SetupTest() {
+	suite.dispatcher = haystack.NewInMemoryDispatcher().(*haystack.InMemoryDispatcher)
+	suite.tracer, suite.closer = haystack.NewTracer("my-service", suite.dispatcher)
+}
+
+func (suite *SaramaTestSuite) TearDownTest() {
+	suite.NoError(suite.closer.Close())
+}
+
+func (suite *SaramaTestSuite) assertTag(span *haystack.SpanRecord, key string, expected interface{}) {
+	value, ok := span.Tag(key)
+	suite.True(ok, "missing tag %s", key)
+	suite.Equal(expected, value, "tag %s", key)
+}
+
+func (suite *SaramaTestSuite) TestCarrierReplacesHeaders() {
+	msg := &sarama.ProducerMessage{Headers: []sarama.RecordHeader{{Key: []byte("Trace-ID"), Value: []byte("old")}}}
+	carrier := ProducerMessageCarrier{Message: msg}
+	carrier.Set("Trace-ID", "new")
+	carrier.Set("Span-ID", "span")
+	suite.Len(msg.Headers, 2)
+
+	headers := make(map[string]string)
+	suite.NoError(ConsumerMessageCarrier{Message: consumed(msg)}.ForeachKey(func(key, val string) error {
+		headers[key] = val
+		return nil
+	}))
+	suite.Equal(map[string]string{"Trace-ID": "new", "Span-ID": "span"}, headers)
+}
+
+func (suite *SaramaTestSuite) TestSyncProducerAndConsumerSpans() {
+	mock := mocks.NewSyncProducer(suite.T(), nil)
+	mock.ExpectSendMessageAndSucceed()
+	producer := WrapSyncProducer(suite.tracer, mock)
+
+	parent := suite.tracer.StartSpan("parent")
+	msg := &sarama.ProducerMessage{Topic: "orders", Value: sarama.StringEncoder("paid")}
+	_, offset, err := producer.SendMessageContext(opentracing.ContextWithSpan(context.Background(), parent), msg)
+	suite.Require().NoError(err)
+	parent.Finish()
+	suite.NoError(producer.Close())
+
+	session := &fakeSession{}
+	var handled opentracing.Span
+	handler := NewConsumerGroupHandler(suite.tracer, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		handled = opentracing.SpanFromContext(ctx)
+		return nil
+	})
+	suite.NoError(handler.ConsumeClaim(session, newFakeClaim(consumed(msg))))
+	suite.Len(session.marked, 1)
+	suite.NotNil(handled)
+
+	parentContext := parent.Context().(*haystack.SpanContext)
+	produce := haystack.NewSpanRecord(suite.dispatcher.SpansByOperation(ProduceOperationName)[0])
+	produceContext := produce.Context().(*haystack.SpanContext)
+	suite.Equal(parentContext.SpanID, produceContext.ParentID)
+	suite.assertTag(produce, string(ext.SpanKind), string(ext.SpanKindProducerEnum))
+	suite.assertTag(produce, string(ext.MessageBusDestination), "orders")
+	suite.assertTag(produce, OffsetTagKey, offset)
+
+	consume := haystack.NewSpanRecord(suite.dispatcher.SpansByOperation(ConsumeOperationName)[0])
+	consumeContext := consume.Context().(*haystack.SpanContext)
+	suite.Equal(parentContext.TraceID, consumeContext.TraceID)
+	suite.Equal(produceContext.SpanID, consumeContext.ParentID)
+	suite.NotEqual(produceContext.SpanID, consumeContext.SpanID)
+	suite.assertTag(consume, string(ext.SpanKind), string(ext.SpanKindConsumerEnum))
+	suite.assertTag(consume, OffsetTagKey, offset)
+}
+
+func (suite *SaramaTestSuite) TestSyncProducerPartialFailure() {
+	producer := WrapSyncProducer(suite.tracer, failingProducer{})
+
+	msgs := []*sarama.ProducerMessage{
+		{Topic: "orders", Value: sarama.StringEncoder("paid")},
+		{Topic: "orders", Value: sarama.StringEncoder("shipped")},
+	}
+	suite.Error(producer.SendMessages(msgs))
+
+	spans := suite.dispatcher.SpansByOperation(ProduceOperationName)
+	suite.Require().Len(spans, 2)
+	suite.assertTag(haystack.NewSpanRecord(spans[0]), OffsetTagKey, int64(1))
+	failed := suite.dispatcher.SpansWithTag(string(ext.Error), true)
+	suite.Require().Len(failed, 1)
+	suite.Equal(spans[1].GetSpanId(), failed[0].GetSpanId())
+}
+
+func (suite *SaramaTestSuite) TestAsyncProducerFinishesOnAcknowledgement() {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(suite.T(), config)
+	mock.ExpectInputAndSucceed()
+	mock.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	producer := WrapAsyncProducer(suite.tracer, mock, config)
+
+	parent := suite.tracer.StartSpan("parent")
+	producer.InputContext(opentracing.ContextWithSpan(context.Background(), parent),
+		&sarama.ProducerMessage{Topic: "orders", Value: sarama.StringEncoder("paid")})
+	success := <-producer.Successes()
+	producer.Input() <- &sarama.ProducerMessage{Topic: "orders", Value: sarama.StringEncoder("shipped")}
+	failure := <-producer.Errors()
+	suite.Equal(sarama.ErrOutOfBrokers, failure.Err)
+	parent.Finish()
+	suite.NoError(producer.Close())
+
+	produce := haystack.NewSpanRecord(suite.dispatcher.SpansByOperation(ProduceOperationName)[0])
+	suite.Equal(parent.Context().(*haystack.SpanContext).SpanID, produce.Context().(*haystack.SpanContext).ParentID)
+	suite.assertTag(produce, OffsetTagKey, success.Offset)
+
+	failed := haystack.NewSpanRecord(suite.dispatcher.SpansByOperation(ProduceOperationName)[1])
+	suite.Equal("", failed.Context().(*haystack.SpanContext).ParentID)
+	suite.assertTag(failed, string(ext.Error), true)
+	suite.NotEmpty(failure.Msg.Headers)
+}
+
+func (suite *SaramaTestSuite) TestConsumerHandlerError() {
+	session := &fakeSession{}
+	handler := NewConsumerGroupHandler(suite.tracer, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		return errors.New("poison message")
+	})
+	err := handler.ConsumeClaim(session, newFakeClaim(&sarama.ConsumerMessage{Topic: "orders"}, &sarama.ConsumerMessage{Topic: "orders"}))
+	suite.EqualError(err, "poison message")
+	suite.Empty(session.marked)
+
+	consume := suite.dispatcher.SpansByOperation(ConsumeOperationName)
+	suite.Len(consume, 1)
+	suite.assertTag(haystack.NewSpanRecord(consume[0]), string(ext.Error), true)
+}
+
+func TestUnitSaramaSuite(t *testing.T) {
+	suite.Run(t, new(SaramaTestSuite))
+}
@@ -0,0 +1,426 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacksql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	// ParamsTagKey is the tag holding the statement parameters, when recorded
+	ParamsTagKey = "db.params"
+
+	// RowsAffectedTagKey is the tag holding the number of rows affected by an Exec
+	RowsAffectedTagKey = "db.rows_affected"
+
+	// SkippedTagKey is set on the span of a call the driver skipped with driver.ErrSkip, database/sql then retries it
+	// another way, which is traced on its own
+	SkippedTagKey = "db.skipped"
+
+	componentName = "database/sql"
+)
+
+// DriverOption is a function that sets some option on the driver wrapper
+type DriverOption func(opts *driverOptions)
+
+type driverOptions struct {
+	dbType       string
+	instance     string
+	allowRoot    bool
+	recordParams bool
+	redact       func(param driver.NamedValue) driver.Value
+}
+
+/*DriverOptions a list of driver options*/
+type DriverOptions struct{}
+
+/*DriverOptionsFactory factory to create multiple driver options*/
+var DriverOptionsFactory DriverOptions
+
+/*DBType sets the db.type tag, defaults to "sql"*/
+func (o DriverOptions) DBType(dbType string) DriverOption {
+	return func(opts *driverOptions) {
+		opts.dbType = dbType
+	}
+}
+
+/*Instance sets the db.instance tag, e.g. the database name*/
+func (o DriverOptions) Instance(instance string) DriverOption {
+	return func(opts *driverOptions) {
+		opts.instance = instance
+	}
+}
+
+/*AllowRoot creates spans for calls whose context carries no span, by default such calls are not traced*/
+func (o DriverOptions) AllowRoot() DriverOption {
+	return func(opts *driverOptions) {
+		opts.allowRoot = true
+	}
+}
+
+/*RecordParams records the statement parameters in the db.params tag, each value passed through redact if it is not nil*/
+func (o DriverOptions) RecordParams(redact func(param driver.NamedValue) driver.Value) DriverOption {
+	return func(opts *driverOptions) {
+		opts.recordParams = true
+		opts.redact = redact
+	}
+}
+
+/*RedactAllParams is a redact function for RecordParams that hides every value*/
+func RedactAllParams(param driver.NamedValue) driver.Value {
+	return "?"
+}
+
+func newDriverOptions(options []DriverOption) *driverOptions {
+	opts := &driverOptions{
+		dbType: "sql",
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	return opts
+}
+
+/*Register wraps the driver and registers it with database/sql under the name*/
+func Register(name string, d driver.Driver, tracer opentracing.Tracer, options ...DriverOption) {
+	sql.Register(name, Wrap(d, tracer, options...))
+}
+
+/*Wrap returns a driver creating child spans of the call's context span for Exec, Query, Prepare, Begin, Commit and Rollback*/
+func Wrap(d driver.Driver, tracer opentracing.Tracer, options ...DriverOption) driver.Driver {
+	return &tracedDriver{
+		driver: d,
+		tracer: &sqlTracer{tracer: tracer, opts: newDriverOptions(options)},
+	}
+}
+
+/*WrapConnector is the driver.Connector flavour of Wrap, for use with sql.OpenDB*/
+func WrapConnector(connector driver.Connector, tracer opentracing.Tracer, options ...DriverOption) driver.Connector {
+	return &tracedConnector{
+		connector: connector,
+		driver:    Wrap(connector.Driver(), tracer, options...).(*tracedDriver),
+	}
+}
+
+type tracedDriver struct {
+	driver driver.Driver
+	tracer *sqlTracer
+}
+
+func (d *tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{conn: conn, tracer: d.tracer}, nil
+}
+
+type tracedConnector struct {
+	connector driver.Connector
+	driver    *tracedDriver
+}
+
+func (c *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{conn: conn, tracer: c.driver.tracer}, nil
+}
+
+func (c *tracedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sqlTracer starts and finishes the spans of the wrapped driver
+type sqlTracer struct {
+	tracer opentracing.Tracer
+	opts   *driverOptions
+}
+
+// start returns nil if the call should not be traced
+func (t *sqlTracer) start(ctx context.Context, operation, query string, args []driver.NamedValue) opentracing.Span {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil && !t.opts.allowRoot {
+		return nil
+	}
+
+	startOptions := []opentracing.StartSpanOption{
+		ext.SpanKindRPCClient,
+		opentracing.Tag{Key: string(ext.Component), Value: componentName},
+		opentracing.Tag{Key: string(ext.DBType), Value: t.opts.dbType},
+	}
+	if parent != nil {
+		startOptions = append(startOptions, opentracing.ChildOf(parent.Context()))
+	}
+	if t.opts.instance != "" {
+		startOptions = append(startOptions, opentracing.Tag{Key: string(ext.DBInstance), Value: t.opts.instance})
+	}
+	if query != "" {
+		startOptions = append(startOptions, opentracing.Tag{Key: string(ext.DBStatement), Value: query})
+	}
+	if t.opts.recordParams && len(args) > 0 {
+		startOptions = append(startOptions, opentracing.Tag{Key: ParamsTagKey, Value: t.formatParams(args)})
+	}
+	return t.tracer.StartSpan("sql."+operation, startOptions...)
+}
+
+func (t *sqlTracer) formatParams(args []driver.NamedValue) string {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if t.opts.redact != nil {
+			values[i] = t.opts.redact(arg)
+		} else {
+			values[i] = arg.Value
+		}
+	}
+	return fmt.Sprintf("%v", values)
+}
+
+func (t *sqlTracer) finish(span opentracing.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err == driver.ErrSkip {
+		span.SetTag(SkippedTagKey, true)
+	} else if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+	}
+	span.Finish()
+}
+
+func (t *sqlTracer) finishExec(span opentracing.Span, result driver.Result, err error) {
+	if span != nil && err == nil && result != nil {
+		if rows, rowsErr := result.RowsAffected(); rowsErr == nil {
+			span.SetTag(RowsAffectedTagKey, rows)
+		}
+	}
+	t.finish(span, err)
+}
+
+type tracedConn struct {
+	conn   driver.Conn
+	tracer *sqlTracer
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	span := c.tracer.start(ctx, "Prepare", query, nil)
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	c.tracer.finish(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{stmt: stmt, query: query, tracer: c.tracer}, nil
+}
+
+func (c *tracedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, txOpts driver.TxOptions) (driver.Tx, error) {
+	beginner, ok := c.conn.(driver.ConnBeginTx)
+	if !ok {
+		// the same checks database/sql does for drivers without BeginTx
+		if sql.IsolationLevel(txOpts.Isolation) != sql.LevelDefault {
+			return nil, errors.New("sql: driver does not support non-default isolation level")
+		}
+		if txOpts.ReadOnly {
+			return nil, errors.New("sql: driver does not support read-only transactions")
+		}
+	}
+
+	span := c.tracer.start(ctx, "Begin", "", nil)
+	var tx driver.Tx
+	var err error
+	if ok {
+		tx, err = beginner.BeginTx(ctx, txOpts)
+	} else {
+		tx, err = c.conn.Begin()
+	}
+	c.tracer.finish(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{tx: tx, ctx: ctx, tracer: c.tracer}, nil
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := c.tracer.start(ctx, "Exec", query, args)
+	result, err := execer.ExecContext(ctx, query, args)
+	c.tracer.finishExec(span, result, err)
+	return result, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := c.tracer.start(ctx, "Query", query, args)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	c.tracer.finish(span, err)
+	return rows, err
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+type tracedTx struct {
+	tx     driver.Tx
+	ctx    context.Context
+	tracer *sqlTracer
+}
+
+func (t *tracedTx) Commit() error {
+	span := t.tracer.start(t.ctx, "Commit", "", nil)
+	err := t.tx.Commit()
+	t.tracer.finish(span, err)
+	return err
+}
+
+func (t *tracedTx) Rollback() error {
+	span := t.tracer.start(t.ctx, "Rollback", "", nil)
+	err := t.tx.Rollback()
+	t.tracer.finish(span, err)
+	return err
+}
+
+type tracedStmt struct {
+	stmt   driver.Stmt
+	query  string
+	tracer *sqlTracer
+}
+
+func (s *tracedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *tracedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *tracedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), toNamedValues(args))
+}
+
+func (s *tracedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), toNamedValues(args))
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	span := s.tracer.start(ctx, "Exec", s.query, args)
+	var result driver.Result
+	var err error
+	if execer, ok := s.stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = toValues(args); err == nil {
+			result, err = s.stmt.Exec(values)
+		}
+	}
+	s.tracer.finishExec(span, result, err)
+	return result, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	span := s.tracer.start(ctx, "Query", s.query, args)
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = toValues(args); err == nil {
+			rows, err = s.stmt.Query(values)
+		}
+	}
+	s.tracer.finish(span, err)
+	return rows, err
+}
+
+func (s *tracedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+func toNamedValues(values []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(values))
+	for i, value := range values {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: value}
+	}
+	return named
+}
+
+func toValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, arg := range named {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("haystacksql: driver does not support the named parameter %s", arg.Name)
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
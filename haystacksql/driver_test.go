@@ -0,0 +1,225 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystacksql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeDriver is an in memory driver whose statements affect one row and return one row
+type fakeDriver struct{}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) { return &fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if query == "BROKEN" {
+		return nil, errors.New("syntax error")
+	}
+	return &fakeStmt{}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if query == "SKIPPED" {
+		return nil, driver.ErrSkip
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error   { return nil }
+func (tx fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct{}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) { return &fakeRows{}, nil }
+
+type fakeRows struct{ done bool }
+
+func (r *fakeRows) Columns() []string { return []string{"id"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(7)
+	return nil
+}
+
+var driverCount int32
+
+type DriverTestSuite struct {
+	suite.Suite
+	dispatcher *haystack.InMemoryDispatcher
+	tracer     opentracing.Tracer
+	closer     io.Closer
+}
+
+func (suite *DriverTestSuite) SetupTest() {
+	suite.dispatcher = haystack.NewInMemoryDispatcher().(*haystack.InMemoryDispatcher)
+	suite.tracer, suite.closer = haystack.NewTracer("my-service", suite.dispatcher)
+}
+
+func (suite *DriverTestSuite) TearDownTest() {
+	suite.NoError(suite.closer.Close())
+}
+
+func (suite *DriverTestSuite) open(options ...DriverOption) *sql.DB {
+	name := "fake-" + string(rune('a'+atomic.AddInt32(&driverCount, 1)))
+	Register(name, fakeDriver{}, suite.tracer, options...)
+	db, err := sql.Open(name, "")
+	suite.Require().NoError(err)
+	return db
+}
+
+func (suite *DriverTestSuite) TestSpansAreChildrenOfContextSpan() {
+	db := suite.open(DriverOptionsFactory.DBType("fakedb"), DriverOptionsFactory.Instance("orders"),
+		DriverOptionsFactory.RecordParams(RedactAllParams))
+	defer func() {
+		suite.NoError(db.Close())
+	}()
+
+	parent := suite.tracer.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+
+	_, err := db.ExecContext(ctx, "UPDATE orders SET state = ? WHERE id = ?", "paid", 7)
+	suite.Require().NoError(err)
+
+	var id int64
+	suite.Require().NoError(db.QueryRowContext(ctx, "SELECT id FROM orders").Scan(&id))
+	suite.Equal(int64(7), id)
+
+	tx, err := db.BeginTx(ctx, nil)
+	suite.Require().NoError(err)
+	suite.Require().NoError(tx.Commit())
+	parent.Finish()
+
+	parentID := parent.Context().(*haystack.SpanContext).SpanID
+	exec := haystack.NewSpanRecord(suite.dispatcher.SpansByOperation("sql.Exec")[0])
+	suite.Equal(parentID, exec.Context().(*haystack.SpanContext).ParentID)
+	suite.assertTag(exec, "db.type", "fakedb")
+	suite.assertTag(exec, "db.instance", "orders")
+	suite.assertTag(exec, "db.statement", "UPDATE orders SET state = ? WHERE id = ?")
+	suite.assertTag(exec, ParamsTagKey, "[? ?]")
+	suite.assertTag(exec, RowsAffectedTagKey, int64(1))
+
+	// the fake driver has no QueryerContext so database/sql falls back to Prepare and Query
+	suite.Len(suite.dispatcher.SpansByOperation("sql.Prepare"), 1)
+	suite.Len(suite.dispatcher.SpansByOperation("sql.Query"), 1)
+	suite.Len(suite.dispatcher.SpansByOperation("sql.Begin"), 1)
+	suite.Len(suite.dispatcher.SpansByOperation("sql.Commit"), 1)
+	suite.Len(suite.dispatcher.ChildrenOf(parentID), 5)
+}
+
+func (suite *DriverTestSuite) TestErrorsAreTagged() {
+	db := suite.open()
+	defer func() {
+		suite.NoError(db.Close())
+	}()
+
+	parent := suite.tracer.StartSpan("parent")
+	_, err := db.PrepareContext(opentracing.ContextWithSpan(context.Background(), parent), "BROKEN")
+	suite.Error(err)
+
+	spans := suite.dispatcher.SpansByOperation("sql.Prepare")
+	suite.Require().NotEmpty(spans)
+	suite.assertTag(haystack.NewSpanRecord(spans[0]), "error", true)
+}
+
+func (suite *DriverTestSuite) TestCallsWithoutSpanAreNotTraced() {
+	db := suite.open()
+	defer func() {
+		suite.NoError(db.Close())
+	}()
+
+	_, err := db.Exec("DELETE FROM orders")
+	suite.Require().NoError(err)
+	suite.Empty(suite.dispatcher.Spans())
+
+	rootDB := suite.open(DriverOptionsFactory.AllowRoot())
+	defer func() {
+		suite.NoError(rootDB.Close())
+	}()
+	_, err = rootDB.Exec("DELETE FROM orders")
+	suite.Require().NoError(err)
+	suite.Len(suite.dispatcher.SpansByOperation("sql.Exec"), 1)
+}
+
+func (suite *DriverTestSuite) TestSkippedCallsAreFinished() {
+	db := suite.open()
+	defer func() {
+		suite.NoError(db.Close())
+	}()
+
+	parent := suite.tracer.StartSpan("parent")
+	_, err := db.ExecContext(opentracing.ContextWithSpan(context.Background(), parent), "SKIPPED")
+	suite.Require().NoError(err)
+
+	// the skipped ExecContext, then the Exec of the prepared statement database/sql falls back to
+	execs := suite.dispatcher.SpansByOperation("sql.Exec")
+	suite.Require().Len(execs, 2)
+	suite.assertTag(haystack.NewSpanRecord(execs[0]), SkippedTagKey, true)
+	_, skipped := haystack.NewSpanRecord(execs[1]).Tag(SkippedTagKey)
+	suite.False(skipped)
+
+	stats := suite.tracer.(*haystack.Tracer).Stats()
+	suite.Equal(stats.SpansStarted-1, stats.SpansFinished, "only the parent is not finished")
+}
+
+func (suite *DriverTestSuite) TestBeginTxOptionsUnsupportedByTheDriver() {
+	db := suite.open()
+	defer func() {
+		suite.NoError(db.Close())
+	}()
+
+	ctx := opentracing.ContextWithSpan(context.Background(), suite.tracer.StartSpan("parent"))
+	_, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	suite.EqualError(err, "sql: driver does not support non-default isolation level")
+	_, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	suite.EqualError(err, "sql: driver does not support read-only transactions")
+	suite.Empty(suite.dispatcher.SpansByOperation("sql.Begin"))
+}
+
+func (suite *DriverTestSuite) assertTag(span *haystack.SpanRecord, key string, expected interface{}) {
+	value, ok := span.Tag(key)
+	suite.True(ok, "tag %s should be present", key)
+	suite.Equal(expected, value, "tag %s", key)
+}
+
+func TestUnitDriverSuite(t *testing.T) {
+	suite.Run(t, new(DriverTestSuite))
+}
@@ -0,0 +1,63 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackzap
+
+import (
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+)
+
+/*Logger adapts a zap logger to haystack.Logger*/
+type Logger struct {
+	logger *zap.SugaredLogger
+}
+
+/*NewLogger creates a logger writing to the given zap logger*/
+func NewLogger(logger *zap.Logger) *Logger {
+	return &Logger{logger: logger.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+/*Error prints the error message*/
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.logger.Errorf(format, v...)
+}
+
+/*Info prints the info message*/
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.logger.Infof(format, v...)
+}
+
+/*Debug prints the debug message*/
+func (l *Logger) Debug(format string, v ...interface{}) {
+	l.logger.Debugf(format, v...)
+}
+
+/*TraceFields returns the traceId and spanId fields of a haystack span context, or nil for any other span context*/
+func TraceFields(spanContext opentracing.SpanContext) []zap.Field {
+	traceID, spanID, ok := haystack.TraceIDs(spanContext)
+	if !ok {
+		return nil
+	}
+	return []zap.Field{zap.String(haystack.TraceIDLogField, traceID), zap.String(haystack.SpanIDLogField, spanID)}
+}
+
+/*WithSpan returns a child of the logger carrying the traceId and spanId of the span*/
+func WithSpan(logger *zap.Logger, span opentracing.Span) *zap.Logger {
+	return logger.With(TraceFields(span.Context())...)
+}
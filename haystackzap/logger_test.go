@@ -0,0 +1,70 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystackzap
+
+import (
+	"testing"
+
+	haystack "github.com/ExpediaDotCom/haystack-client-go"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type LoggerTestSuite struct {
+	suite.Suite
+	core *zap.Logger
+	logs *observer.ObservedLogs
+}
+
+func (suite *LoggerTestSuite) SetupTest() {
+	var observed zapcore.Core
+	observed, suite.logs = observer.New(zapcore.DebugLevel)
+	suite.core = zap.New(observed)
+}
+
+func (suite *LoggerTestSuite) TestLoggerLevels() {
+	logger := NewLogger(suite.core)
+	logger.Info("dispatched %d spans", 3)
+	logger.Error("fail to dispatch: %s", "timeout")
+	logger.Debug("queue is empty")
+
+	entries := suite.logs.AllUntimed()
+	suite.Require().Len(entries, 3)
+	suite.Equal(zapcore.InfoLevel, entries[0].Level)
+	suite.Equal("dispatched 3 spans", entries[0].Message)
+	suite.Equal(zapcore.ErrorLevel, entries[1].Level)
+	suite.Equal(zapcore.DebugLevel, entries[2].Level)
+}
+
+func (suite *LoggerTestSuite) TestWithSpan() {
+	tracer, closer := haystack.NewTracer("my-service", haystack.NewInMemoryDispatcher())
+	defer closer.Close()
+	span := tracer.StartSpan("op")
+
+	WithSpan(suite.core, span).Info("order paid")
+
+	fields := suite.logs.AllUntimed()[0].ContextMap()
+	suite.Equal(span.Context().(*haystack.SpanContext).TraceID, fields[haystack.TraceIDLogField])
+	suite.Equal(span.Context().(*haystack.SpanContext).SpanID, fields[haystack.SpanIDLogField])
+}
+
+func TestUnitLoggerSuite(t *testing.T) {
+	suite.Run(t, new(LoggerTestSuite))
+}
@@ -0,0 +1,110 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*IDGenerator creates the trace and span ids, it is called concurrently*/
+type IDGenerator func() string
+
+/*UUIDv1ID returns a time based v1 uuid, the default id of the tracer. The uuid package generates them under a global lock*/
+func UUIDv1ID() string {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return FastUUIDv4ID()
+	}
+	return id.String()
+}
+
+/*UUIDv4ID returns a random v4 uuid read from crypto/rand*/
+func UUIDv4ID() string {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return FastUUIDv4ID()
+	}
+	return id.String()
+}
+
+/*FastUUIDv4ID returns a random v4 uuid drawn from the lock free pseudo random generator*/
+func FastUUIDv4ID() string {
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[:8], randomUint64())
+	binary.BigEndian.PutUint64(id[8:], randomUint64())
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id.String()
+}
+
+/*HexID64 returns a non zero 64-bit id as 16 lowercase hex characters, the span id format of W3C trace context and B3, drawn from the lock free pseudo random generator*/
+func HexID64() string {
+	var raw [8]byte
+	binary.BigEndian.PutUint64(raw[:], nonZeroUint64())
+	var encoded [16]byte
+	hex.Encode(encoded[:], raw[:])
+	return string(encoded[:])
+}
+
+/*HexID128 returns a non zero 128-bit id as 32 lowercase hex characters, the trace id format of W3C trace context and B3, drawn from the lock free pseudo random generator*/
+func HexID128() string {
+	var raw [16]byte
+	binary.BigEndian.PutUint64(raw[:8], randomUint64())
+	binary.BigEndian.PutUint64(raw[8:], nonZeroUint64())
+	var encoded [32]byte
+	hex.Encode(encoded[:], raw[:])
+	return string(encoded[:])
+}
+
+// randSources holds pseudo random generators each seeded from crypto/rand. sync.Pool keeps
+// them per processor so concurrent goroutines neither share a generator nor take a lock
+var randSources = sync.Pool{
+	New: func() interface{} {
+		return rand.New(rand.NewSource(cryptoSeed()))
+	},
+}
+
+func cryptoSeed() int64 {
+	var seed [8]byte
+	if _, err := cryptorand.Read(seed[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(seed[:]))
+}
+
+func randomUint64() uint64 {
+	source := randSources.Get().(*rand.Rand)
+	value := source.Uint64()
+	randSources.Put(source)
+	return value
+}
+
+func nonZeroUint64() uint64 {
+	for {
+		if value := randomUint64(); value != 0 {
+			return value
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+var (
+	uuidV1Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-1[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	uuidV4Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	hex64Pattern  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	hex128Pattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+)
+
+type IDGeneratorTestSuite struct {
+	suite.Suite
+}
+
+func (suite *IDGeneratorTestSuite) assertUnique(generator IDGenerator, pattern *regexp.Regexp) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ids := make(map[string]bool)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := generator()
+				mu.Lock()
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	suite.Len(ids, 8000)
+	for id := range ids {
+		suite.Regexp(pattern, id)
+	}
+}
+
+func (suite *IDGeneratorTestSuite) TestFormats() {
+	suite.assertUnique(UUIDv1ID, uuidV1Pattern)
+	suite.assertUnique(UUIDv4ID, uuidV4Pattern)
+	suite.assertUnique(FastUUIDv4ID, uuidV4Pattern)
+	suite.assertUnique(HexID64, hex64Pattern)
+	suite.assertUnique(HexID128, hex128Pattern)
+}
+
+func (suite *IDGeneratorTestSuite) TestTracerOptions() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	spanContext := tracer.StartSpan("op").Context().(*SpanContext)
+	suite.Regexp(uuidV1Pattern, spanContext.TraceID)
+	suite.Regexp(uuidV1Pattern, spanContext.SpanID)
+
+	tracer, closer = NewTracer("my-service", NewInMemoryDispatcher(), TracerOptionsFactory.IDGenerators(HexID128, HexID64))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	root := tracer.StartSpan("root")
+	child := tracer.StartSpan("child", opentracing.ChildOf(root.Context()))
+	suite.Regexp(hex128Pattern, root.Context().(*SpanContext).TraceID)
+	suite.Regexp(hex64Pattern, root.Context().(*SpanContext).SpanID)
+	suite.Regexp(hex64Pattern, child.Context().(*SpanContext).SpanID)
+
+	tracer, closer = NewTracer("my-service", NewInMemoryDispatcher(), TracerOptionsFactory.IDGenerator(UUIDv4ID))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	spanContext = tracer.StartSpan("op").Context().(*SpanContext)
+	suite.Regexp(uuidV4Pattern, spanContext.TraceID)
+	suite.Regexp(uuidV4Pattern, spanContext.SpanID)
+}
+
+func TestUnitIDGeneratorSuite(t *testing.T) {
+	suite.Run(t, new(IDGeneratorTestSuite))
+}
+
+func BenchmarkStartSpan(b *testing.B) {
+	generators := []struct {
+		name    string
+		options []TracerOption
+	}{
+		{"UUIDv1", nil},
+		{"UUIDv4", []TracerOption{TracerOptionsFactory.IDGenerator(UUIDv4ID)}},
+		{"FastUUIDv4", []TracerOption{TracerOptionsFactory.IDGenerator(FastUUIDv4ID)}},
+		{"Hex128-Hex64", []TracerOption{TracerOptionsFactory.IDGenerators(HexID128, HexID64)}},
+	}
+	for _, generator := range generators {
+		tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(), generator.options...)
+		b.Run(generator.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					tracer.StartSpan("op")
+				}
+			})
+		})
+		if err := closer.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
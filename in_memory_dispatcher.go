@@ -0,0 +1,162 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*InMemoryDispatcher records the spans in memory as proto spans, converted the same way the remote dispatchers convert them. It is safe for concurrent use*/
+type InMemoryDispatcher struct {
+	mu     sync.Mutex
+	spans  []*Span
+	logger Logger
+
+	// changed is closed on the next recorded span to wake up WaitForSpans
+	changed chan struct{}
+}
+
+/*NewInMemoryDispatcher creates a new in memory dispatcher*/
+func NewInMemoryDispatcher() Dispatcher {
+	return &InMemoryDispatcher{}
+}
+
+/*Name gives the Dispatcher name*/
+func (d *InMemoryDispatcher) Name() string {
+	return "InMemoryDispatcher"
+}
+
+/*SetLogger sets the logger to use*/
+func (d *InMemoryDispatcher) SetLogger(logger Logger) {
+	d.logger = logger
+}
+
+/*Dispatch dispatches the span object*/
+func (d *InMemoryDispatcher) Dispatch(span FinishedSpan) {
+	d.record(toProtoSpan(span))
+}
+
+/*DispatchProtoSpan dispatches proto span object*/
+func (d *InMemoryDispatcher) DispatchProtoSpan(span *Span) {
+	d.record(proto.Clone(span).(*Span))
+}
+
+func (d *InMemoryDispatcher) record(span *Span) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.spans = append(d.spans, span)
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+}
+
+/*Spans returns all the recorded spans in the order they were dispatched*/
+func (d *InMemoryDispatcher) Spans() []*Span {
+	return d.FindSpans(func(*Span) bool { return true })
+}
+
+/*FindSpans returns the recorded spans matching the predicate*/
+func (d *InMemoryDispatcher) FindSpans(predicate func(span *Span) bool) []*Span {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var spans []*Span
+	for _, span := range d.spans {
+		if predicate(span) {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+/*SpansByTraceID returns the recorded spans of the trace*/
+func (d *InMemoryDispatcher) SpansByTraceID(traceID string) []*Span {
+	return d.FindSpans(func(span *Span) bool {
+		return span.GetTraceId() == traceID
+	})
+}
+
+/*SpansByOperation returns the recorded spans with the operation name*/
+func (d *InMemoryDispatcher) SpansByOperation(operationName string) []*Span {
+	return d.FindSpans(func(span *Span) bool {
+		return span.GetOperationName() == operationName
+	})
+}
+
+/*SpansWithTag returns the recorded spans having a tag with the key and value*/
+func (d *InMemoryDispatcher) SpansWithTag(key string, value interface{}) []*Span {
+	expected := ConvertToProtoTag(key, value)
+	return d.FindSpans(func(span *Span) bool {
+		for _, tag := range span.GetTags() {
+			if proto.Equal(tag, expected) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+/*ChildrenOf returns the recorded spans whose parent is the given span id*/
+func (d *InMemoryDispatcher) ChildrenOf(spanID string) []*Span {
+	return d.FindSpans(func(span *Span) bool {
+		return span.GetParentSpanId() == spanID
+	})
+}
+
+/*WaitForSpans blocks until at least n spans are recorded and returns them, or returns an error with the spans recorded so far on timeout*/
+func (d *InMemoryDispatcher) WaitForSpans(n int, timeout time.Duration) ([]*Span, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		d.mu.Lock()
+		count := len(d.spans)
+		if d.changed == nil {
+			d.changed = make(chan struct{})
+		}
+		changed := d.changed
+		d.mu.Unlock()
+
+		if count >= n {
+			return d.Spans(), nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return d.Spans(), fmt.Errorf("timed out after %v waiting for %d spans, %d recorded", timeout, n, count)
+		}
+	}
+}
+
+/*Reset drops all the recorded spans*/
+func (d *InMemoryDispatcher) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.spans = nil
+}
+
+/*Close down the inMemory dispatcher*/
+func (d *InMemoryDispatcher) Close() {
+	d.Reset()
+}
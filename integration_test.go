@@ -29,26 +29,6 @@ import (
 	"github.com/opentracing/opentracing-go/ext"
 )
 
-type consoleLogger struct{}
-
-/*Error prints the error message*/
-func (logger *consoleLogger) Error(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-	fmt.Print("\n")
-}
-
-/*Info prints the info message*/
-func (logger *consoleLogger) Info(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-	fmt.Print("\n")
-}
-
-/*Debug prints the info message*/
-func (logger *consoleLogger) Debug(format string, v ...interface{}) {
-	fmt.Printf(format, v...)
-	fmt.Print("\n")
-}
-
 func createKafkaConsumer() sarama.PartitionConsumer {
 	consumer, err := sarama.NewConsumer([]string{"kafkasvc:9092"}, nil)
 
@@ -141,7 +121,7 @@ ConsumerLoop:
 
 func TestIntegration(t *testing.T) {
 	consumer := createKafkaConsumer()
-	agentTracer, agentCloser := NewTracer("dummy-service", NewAgentDispatcher("haystack_agent", 35000, 3*time.Second, 1000), TracerOptionsFactory.Tag("appVer", "v1.1"), TracerOptionsFactory.Logger(&consoleLogger{}))
+	agentTracer, agentCloser := NewTracer("dummy-service", NewAgentDispatcher("haystack_agent", 35000, 3*time.Second, 1000), TracerOptionsFactory.Tag("appVer", "v1.1"), TracerOptionsFactory.Logger(NewStdLogger(nil)))
 	defer func() {
 		err := agentCloser.Close()
 		if err != nil {
@@ -152,7 +132,7 @@ func TestIntegration(t *testing.T) {
 	executeTest(agentTracer, consumer, t)
 
 	httpDispatcher := NewHTTPDispatcher("http://haystack_collector:8080/span", 3*time.Second, make(map[string]string), 1000)
-	_, httpCloser := NewTracer("dummy-service", httpDispatcher, TracerOptionsFactory.Tag("appVer", "v1.1"), TracerOptionsFactory.Logger(&consoleLogger{}))
+	_, httpCloser := NewTracer("dummy-service", httpDispatcher, TracerOptionsFactory.Tag("appVer", "v1.1"), TracerOptionsFactory.Logger(NewStdLogger(nil)))
 	defer func() {
 		err := httpCloser.Close()
 		if err != nil {
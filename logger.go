@@ -17,6 +17,22 @@
 
 package haystack
 
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	// TraceIDLogField is the field holding the trace id in application log records
+	TraceIDLogField = "traceId"
+
+	// SpanIDLogField is the field holding the span id in application log records
+	SpanIDLogField = "spanId"
+)
+
 /*Logger defines a new logger interface*/
 type Logger interface {
 	Info(format string, v ...interface{})
@@ -35,3 +51,53 @@ func (logger NullLogger) Info(format string, v ...interface{}) {}
 
 /*Debug prints the info message*/
 func (logger NullLogger) Debug(format string, v ...interface{}) {}
+
+/*StdLogger adapts a logger of the standard log package, every message is prefixed with its level*/
+type StdLogger struct {
+	logger *log.Logger
+}
+
+/*NewStdLogger creates a logger writing to the given standard logger, or to stderr if it is nil*/
+func NewStdLogger(logger *log.Logger) *StdLogger {
+	if logger == nil {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &StdLogger{logger: logger}
+}
+
+/*Error prints the error message*/
+func (logger *StdLogger) Error(format string, v ...interface{}) {
+	logger.print("ERROR", format, v)
+}
+
+/*Info prints the info message*/
+func (logger *StdLogger) Info(format string, v ...interface{}) {
+	logger.print("INFO", format, v)
+}
+
+/*Debug prints the debug message*/
+func (logger *StdLogger) Debug(format string, v ...interface{}) {
+	logger.print("DEBUG", format, v)
+}
+
+func (logger *StdLogger) print(level string, format string, v []interface{}) {
+	_ = logger.logger.Output(3, level+" "+fmt.Sprintf(format, v...))
+}
+
+/*TraceIDs returns the trace and span ids of a haystack span context, to be logged as TraceIDLogField and SpanIDLogField so logs and traces can be joined. ok is false for any other span context*/
+func TraceIDs(spanContext opentracing.SpanContext) (traceID string, spanID string, ok bool) {
+	sc, ok := spanContext.(*SpanContext)
+	if !ok || sc == nil || !sc.IsValid() {
+		return "", "", false
+	}
+	return sc.TraceID, sc.SpanID, true
+}
+
+/*FormatTraceIDs returns the ids of the span context as "traceId=<id> spanId=<id>", for plain text log lines, or an empty string if it is not a haystack span context*/
+func FormatTraceIDs(spanContext opentracing.SpanContext) string {
+	traceID, spanID, ok := TraceIDs(spanContext)
+	if !ok {
+		return ""
+	}
+	return TraceIDLogField + "=" + traceID + " " + SpanIDLogField + "=" + spanID
+}
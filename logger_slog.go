@@ -0,0 +1,93 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+/*SlogLogger adapts a log/slog logger*/
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+/*NewSlogLogger creates a logger writing to the given slog logger, or to slog.Default() if it is nil*/
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+/*Error prints the error message*/
+func (logger *SlogLogger) Error(format string, v ...interface{}) {
+	logger.logger.Error(fmt.Sprintf(format, v...))
+}
+
+/*Info prints the info message*/
+func (logger *SlogLogger) Info(format string, v ...interface{}) {
+	logger.logger.Info(fmt.Sprintf(format, v...))
+}
+
+/*Debug prints the debug message*/
+func (logger *SlogLogger) Debug(format string, v ...interface{}) {
+	logger.logger.Debug(fmt.Sprintf(format, v...))
+}
+
+/*SlogTraceAttrs returns the traceId and spanId attributes of a haystack span context, or nil for any other span context*/
+func SlogTraceAttrs(spanContext opentracing.SpanContext) []slog.Attr {
+	traceID, spanID, ok := TraceIDs(spanContext)
+	if !ok {
+		return nil
+	}
+	return []slog.Attr{slog.String(TraceIDLogField, traceID), slog.String(SpanIDLogField, spanID)}
+}
+
+/*NewSlogTraceHandler wraps the handler so that records logged with a context holding a span, e.g. with slog.InfoContext, carry its traceId and spanId*/
+func NewSlogTraceHandler(handler slog.Handler) slog.Handler {
+	return &slogTraceHandler{Handler: handler}
+}
+
+type slogTraceHandler struct {
+	slog.Handler
+}
+
+func (h *slogTraceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if attrs := SlogTraceAttrs(span.Context()); attrs != nil {
+			record = record.Clone()
+			record.AddAttrs(attrs...)
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *slogTraceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &slogTraceHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *slogTraceHandler) WithGroup(name string) slog.Handler {
+	return &slogTraceHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,86 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type SlogLoggerTestSuite struct {
+	suite.Suite
+	buf bytes.Buffer
+}
+
+func (suite *SlogLoggerTestSuite) SetupTest() {
+	suite.buf.Reset()
+}
+
+func (suite *SlogLoggerTestSuite) handler() slog.Handler {
+	return slog.NewJSONHandler(&suite.buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+}
+
+func (suite *SlogLoggerTestSuite) record() map[string]interface{} {
+	record := make(map[string]interface{})
+	suite.Require().NoError(json.Unmarshal(suite.buf.Bytes(), &record))
+	suite.buf.Reset()
+	return record
+}
+
+func (suite *SlogLoggerTestSuite) TestSlogLoggerLevels() {
+	logger := NewSlogLogger(slog.New(suite.handler()))
+
+	logger.Error("fail to dispatch: %s", "timeout")
+	record := suite.record()
+	suite.Equal("ERROR", record["level"])
+	suite.Equal("fail to dispatch: timeout", record["msg"])
+
+	logger.Debug("queue is empty")
+	suite.Equal("DEBUG", suite.record()["level"])
+}
+
+func (suite *SlogLoggerTestSuite) TestTraceHandlerAddsIDs() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	logger := slog.New(NewSlogTraceHandler(suite.handler())).With("app", "orders")
+
+	span := tracer.StartSpan("op")
+	logger.InfoContext(opentracing.ContextWithSpan(context.Background(), span), "order paid")
+	record := suite.record()
+	suite.Equal(span.Context().(*SpanContext).TraceID, record[TraceIDLogField])
+	suite.Equal(span.Context().(*SpanContext).SpanID, record[SpanIDLogField])
+	suite.Equal("orders", record["app"])
+
+	logger.InfoContext(context.Background(), "no span")
+	suite.NotContains(suite.record(), TraceIDLogField)
+}
+
+func TestUnitSlogLoggerSuite(t *testing.T) {
+	suite.Run(t, new(SlogLoggerTestSuite))
+}
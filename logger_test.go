@@ -0,0 +1,66 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type LoggerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LoggerTestSuite) TestStdLoggerPrefixesLevel() {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0))
+
+	logger.Info("dispatched %d spans", 3)
+	logger.Error("fail to dispatch: %s", "timeout")
+	logger.Debug("queue is empty")
+
+	suite.Equal("INFO dispatched 3 spans\nERROR fail to dispatch: timeout\nDEBUG queue is empty\n", buf.String())
+}
+
+func (suite *LoggerTestSuite) TestTraceIDs() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	span := tracer.StartSpan("op")
+	spanContext := span.Context().(*SpanContext)
+	traceID, spanID, ok := TraceIDs(span.Context())
+	suite.True(ok)
+	suite.Equal(spanContext.TraceID, traceID)
+	suite.Equal(spanContext.SpanID, spanID)
+	suite.Equal("traceId="+traceID+" spanId="+spanID, FormatTraceIDs(span.Context()))
+
+	noopContext := opentracing.NoopTracer{}.StartSpan("op").Context()
+	_, _, ok = TraceIDs(noopContext)
+	suite.False(ok)
+	suite.Equal("", FormatTraceIDs(noopContext))
+}
+
+func TestUnitLoggerSuite(t *testing.T) {
+	suite.Run(t, new(LoggerTestSuite))
+}
@@ -18,7 +18,9 @@
 package haystack
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 
@@ -64,12 +66,33 @@ func (c URLCodex) Decode(value string) string {
 	return ""
 }
 
+/*Base64Codex encodes the values with the url safe base64 alphabet without padding, the encoded values are valid http header values and tokens*/
+type Base64Codex struct{}
+
+/*Encode base64 encodes the value*/
+func (c Base64Codex) Encode(value string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+/*Decode base64 decodes the value with or without padding, an invalid value decodes to an empty string*/
+func (c Base64Codex) Decode(value string) string {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err == nil {
+		return string(decoded)
+	}
+	return ""
+}
+
 /*PropagatorOpts defines the options need by a propagator*/
 type PropagatorOpts struct {
 	TraceIDKEYName       string
 	SpanIDKEYName        string
 	ParentSpanIDKEYName  string
 	BaggagePrefixKEYName string
+
+	// PreserveBaggageKeyCase extracts the baggage keys as they are in the carrier instead of lowercasing them.
+	// The HTTPHeaders carriers canonicalize the header names, their keys are always lowercased
+	PreserveBaggageKeyCase bool
 }
 
 var defaultPropagatorOpts = PropagatorOpts{}
@@ -132,7 +155,7 @@ func (p *TextMapPropagator) Inject(ctx *SpanContext, carrier interface{}) error
 	return nil
 }
 
-/*Extract the span context from the carrier*/
+/*Extract the span context from the carrier, the baggage keys come back lowercased unless PreserveBaggageKeyCase is set for a TextMap carrier*/
 func (p *TextMapPropagator) Extract(carrier interface{}) (*SpanContext, error) {
 	textMapReader, ok := carrier.(opentracing.TextMapReader)
 
@@ -149,12 +172,17 @@ func (p *TextMapPropagator) Extract(carrier interface{}) (*SpanContext, error) {
 	spanID := ""
 	parentSpanID := ""
 
+	baggagePrefix := p.opts.BaggageKeyPrefix()
+	preserveKeyCase := p.opts.PreserveBaggageKeyCase && !isHeaderCarrier(carrier)
+
 	baggage := make(map[string]string)
 	err := textMapReader.ForeachKey(func(k, v string) error {
 		lcKey := strings.ToLower(k)
 
-		if strings.HasPrefix(lcKey, baggageKeyLowerCasePrefix) {
-			keySansPrefix := lcKey[len(p.opts.BaggageKeyPrefix()):]
+		if preserveKeyCase && len(k) >= len(baggagePrefix) && strings.EqualFold(k[:len(baggagePrefix)], baggagePrefix) {
+			baggage[k[len(baggagePrefix):]] = p.codex.Decode(v)
+		} else if strings.HasPrefix(lcKey, baggageKeyLowerCasePrefix) {
+			keySansPrefix := lcKey[len(baggagePrefix):]
 			baggage[keySansPrefix] = p.codex.Decode(v)
 		} else if lcKey == traceIDKeyLowerCase {
 			traceID = v
@@ -179,6 +207,15 @@ func (p *TextMapPropagator) Extract(carrier interface{}) (*SpanContext, error) {
 	}, nil
 }
 
+// isHeaderCarrier tells if the carrier canonicalizes the case of its keys
+func isHeaderCarrier(carrier interface{}) bool {
+	switch carrier.(type) {
+	case opentracing.HTTPHeadersCarrier, http.Header:
+		return true
+	}
+	return false
+}
+
 /*NewDefaultTextMapPropagator returns a default text map propagator*/
 func NewDefaultTextMapPropagator() *TextMapPropagator {
 	return NewTextMapPropagator(defaultPropagatorOpts, defaultCodex)
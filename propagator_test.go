@@ -0,0 +1,80 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type PropagatorTestSuite struct {
+	suite.Suite
+}
+
+func (suite *PropagatorTestSuite) roundTrip(propagator Propagator, carrier interface{}, baggage map[string]string) map[string]string {
+	ctx := &SpanContext{TraceID: "t1", SpanID: "s1", Baggage: baggage}
+	suite.Require().NoError(propagator.Inject(ctx, carrier))
+	extracted, err := propagator.Extract(carrier)
+	suite.Require().NoError(err)
+	suite.Equal("t1", extracted.TraceID)
+	return extracted.Baggage
+}
+
+func (suite *PropagatorTestSuite) TestBase64Codex() {
+	codex := Base64Codex{}
+	suite.Equal("w6lsw6h2ZT8", codex.Encode("élève?"))
+	suite.Equal("élève?", codex.Decode("w6lsw6h2ZT8"))
+	suite.Equal("élève?", codex.Decode("w6lsw6h2ZT8="))
+	suite.Equal("", codex.Decode("not base64!"))
+}
+
+func (suite *PropagatorTestSuite) TestKeysAreLowercasedByDefault() {
+	baggage := suite.roundTrip(NewDefaultTextMapPropagator(), opentracing.TextMapCarrier{}, map[string]string{"userId": "42"})
+	suite.Equal(map[string]string{"userid": "42"}, baggage)
+}
+
+func (suite *PropagatorTestSuite) TestKeyCaseIsPreservedWhereTheCarrierAllowsIt() {
+	propagator := NewTextMapPropagator(PropagatorOpts{PreserveBaggageKeyCase: true}, URLCodex{})
+
+	baggage := suite.roundTrip(propagator, opentracing.TextMapCarrier{}, map[string]string{"userId": "42"})
+	suite.Equal(map[string]string{"userId": "42"}, baggage)
+
+	// http headers are canonicalized, e.g. Baggage-Userid
+	baggage = suite.roundTrip(propagator, opentracing.HTTPHeadersCarrier{}, map[string]string{"userId": "42"})
+	suite.Equal(map[string]string{"userid": "42"}, baggage)
+}
+
+func (suite *PropagatorTestSuite) TestUTF8RoundTrip() {
+	utf8Baggage := map[string]string{"Ünïcode Kéy": "välue, with; \"separators\"\n", "日本": "東京", "emoji": "🚀"}
+	for _, codex := range []Codex{DefaultCodex{}, URLCodex{}, Base64Codex{}} {
+		propagator := NewTextMapPropagator(PropagatorOpts{PreserveBaggageKeyCase: true}, codex)
+		suite.Equal(utf8Baggage, suite.roundTrip(propagator, opentracing.TextMapCarrier{}, utf8Baggage), "%T", codex)
+	}
+
+	headerBaggage := map[string]string{"user": "Zoë\r\n", "city": "東京"}
+	for _, codex := range []Codex{URLCodex{}, Base64Codex{}} {
+		propagator := NewTextMapPropagator(PropagatorOpts{}, codex)
+		suite.Equal(headerBaggage, suite.roundTrip(propagator, opentracing.HTTPHeadersCarrier{}, headerBaggage), "%T", codex)
+	}
+}
+
+func TestUnitPropagatorSuite(t *testing.T) {
+	suite.Run(t, new(PropagatorTestSuite))
+}
@@ -0,0 +1,260 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// REDOverflowOperation replaces the operation name of the spans beyond the series limit
+const REDOverflowOperation = "__overflow__"
+
+/*DefaultREDBuckets are the upper bounds of the default latency histogram buckets*/
+var DefaultREDBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+/*REDKey identifies a series of the rate, error and duration metrics*/
+type REDKey struct {
+	ServiceName   string
+	OperationName string
+	SpanKind      string
+	Error         bool
+}
+
+/*REDSeries holds the cumulative metrics of a key, BucketCounts[i] counts the spans not longer than Buckets[i] and the last count the ones longer than every bucket*/
+type REDSeries struct {
+	Key          REDKey
+	Count        uint64
+	Sum          time.Duration
+	Buckets      []time.Duration
+	BucketCounts []uint64
+}
+
+/*MetricsSink receives the duration of every finished span with its RED key, e.g. to feed a metrics library*/
+type MetricsSink interface {
+	Observe(key REDKey, duration time.Duration)
+}
+
+// REDOption is a function that sets some option on the RED dispatcher
+type REDOption func(opts *redOptions)
+
+type redOptions struct {
+	sinks     []MetricsSink
+	buckets   []time.Duration
+	maxSeries int
+}
+
+/*REDOptions a list of RED dispatcher options*/
+type REDOptions struct{}
+
+/*REDOptionsFactory factory to create multiple RED dispatcher options*/
+var REDOptionsFactory REDOptions
+
+/*Sink adds a sink observing every finished span*/
+func (o REDOptions) Sink(sink MetricsSink) REDOption {
+	return func(opts *redOptions) {
+		opts.sinks = append(opts.sinks, sink)
+	}
+}
+
+/*Buckets sets the upper bounds of the latency histogram buckets, defaults to DefaultREDBuckets*/
+func (o REDOptions) Buckets(buckets []time.Duration) REDOption {
+	return func(opts *redOptions) {
+		opts.buckets = buckets
+	}
+}
+
+/*MaxSeries sets the number of distinct keys tracked, defaults to 1000. Spans of new keys beyond the limit are recorded with the REDOverflowOperation operation name*/
+func (o REDOptions) MaxSeries(maxSeries int) REDOption {
+	return func(opts *redOptions) {
+		opts.maxSeries = maxSeries
+	}
+}
+
+/*REDDispatcher derives rate, error and duration metrics from the finished spans before handing them to the next dispatcher*/
+type REDDispatcher struct {
+	next Dispatcher
+	opts redOptions
+
+	mu       sync.Mutex
+	series   map[REDKey]*REDSeries
+	overflow uint64
+}
+
+/*NewREDDispatcher wraps the dispatcher so that the metrics of every span it dispatches are recorded*/
+func NewREDDispatcher(next Dispatcher, options ...REDOption) *REDDispatcher {
+	opts := redOptions{
+		buckets:   DefaultREDBuckets,
+		maxSeries: 1000,
+	}
+	for _, option := range options {
+		option(&opts)
+	}
+	buckets := append([]time.Duration(nil), opts.buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	opts.buckets = buckets
+
+	return &REDDispatcher{
+		next:   next,
+		opts:   opts,
+		series: make(map[REDKey]*REDSeries),
+	}
+}
+
+/*Name gives the Dispatcher name*/
+func (d *REDDispatcher) Name() string {
+	return "REDDispatcher(" + d.next.Name() + ")"
+}
+
+/*SetLogger sets the logger to use*/
+func (d *REDDispatcher) SetLogger(logger Logger) {
+	d.next.SetLogger(logger)
+}
+
+/*Dispatch records the metrics of the span and dispatches it*/
+func (d *REDDispatcher) Dispatch(span FinishedSpan) {
+	kind, isError := redTags(span.Tags())
+	d.record(REDKey{
+		ServiceName:   span.ServiceName(),
+		OperationName: span.OperationName(),
+		SpanKind:      kind,
+		Error:         isError,
+	}, span.Duration())
+	d.next.Dispatch(span)
+}
+
+/*DispatchProtoSpan records the metrics of the proto span and dispatches it*/
+func (d *REDDispatcher) DispatchProtoSpan(span *Span) {
+	record := NewSpanRecord(span)
+	kind, isError := redTags(record.Tags())
+	d.record(REDKey{
+		ServiceName:   record.ServiceName(),
+		OperationName: record.OperationName(),
+		SpanKind:      kind,
+		Error:         isError,
+	}, record.Duration())
+	d.next.DispatchProtoSpan(span)
+}
+
+/*Close closes the next dispatcher*/
+func (d *REDDispatcher) Close() {
+	d.next.Close()
+}
+
+/*Stats returns the self metrics of the next dispatcher if it reports them*/
+func (d *REDDispatcher) Stats() Stats {
+	if reporter, ok := d.next.(StatsReporter); ok {
+		return reporter.Stats()
+	}
+	return Stats{}
+}
+
+/*Series returns a copy of the metrics recorded so far, sorted by key*/
+func (d *REDDispatcher) Series() []REDSeries {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	series := make([]REDSeries, 0, len(d.series))
+	for _, s := range d.series {
+		copied := *s
+		copied.BucketCounts = append([]uint64(nil), s.BucketCounts...)
+		series = append(series, copied)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i].Key, series[j].Key
+		if a.ServiceName != b.ServiceName {
+			return a.ServiceName < b.ServiceName
+		}
+		if a.OperationName != b.OperationName {
+			return a.OperationName < b.OperationName
+		}
+		if a.SpanKind != b.SpanKind {
+			return a.SpanKind < b.SpanKind
+		}
+		return !a.Error && b.Error
+	})
+	return series
+}
+
+/*OverflowCount returns the number of spans recorded under REDOverflowOperation because of the series limit*/
+func (d *REDDispatcher) OverflowCount() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.overflow
+}
+
+func (d *REDDispatcher) record(key REDKey, duration time.Duration) {
+	d.mu.Lock()
+	series, ok := d.series[key]
+	if !ok {
+		if len(d.series) >= d.opts.maxSeries {
+			d.overflow++
+			key.OperationName = REDOverflowOperation
+			series, ok = d.series[key]
+		}
+		if !ok {
+			series = &REDSeries{
+				Key:          key,
+				Buckets:      d.opts.buckets,
+				BucketCounts: make([]uint64, len(d.opts.buckets)+1),
+			}
+			d.series[key] = series
+		}
+	}
+	series.Count++
+	series.Sum += duration
+	series.BucketCounts[sort.Search(len(series.Buckets), func(i int) bool { return duration <= series.Buckets[i] })]++
+	d.mu.Unlock()
+
+	for _, sink := range d.opts.sinks {
+		sink.Observe(key, duration)
+	}
+}
+
+// redTags returns the span kind and the error flag of the tags, the last value of a tag wins
+func redTags(tags []opentracing.Tag) (string, bool) {
+	var kind string
+	var isError bool
+	for _, tag := range tags {
+		switch tag.Key {
+		case string(ext.SpanKind):
+			switch value := tag.Value.(type) {
+			case string:
+				kind = value
+			case ext.SpanKindEnum:
+				kind = string(value)
+			}
+		case string(ext.Error):
+			switch value := tag.Value.(type) {
+			case bool:
+				isError = value
+			case string:
+				isError = value == "true"
+			}
+		}
+	}
+	return kind, isError
+}
@@ -0,0 +1,149 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/suite"
+)
+
+// recordingSink keeps the observed keys
+type recordingSink struct {
+	keys []REDKey
+}
+
+func (s *recordingSink) Observe(key REDKey, duration time.Duration) {
+	s.keys = append(s.keys, key)
+}
+
+type REDMetricsTestSuite struct {
+	suite.Suite
+	memory *InMemoryDispatcher
+	sink   *recordingSink
+	start  time.Time
+}
+
+func (suite *REDMetricsTestSuite) SetupTest() {
+	suite.memory = NewInMemoryDispatcher().(*InMemoryDispatcher)
+	suite.sink = &recordingSink{}
+	suite.start = time.Now()
+}
+
+func (suite *REDMetricsTestSuite) finish(tracer opentracing.Tracer, operationName string, duration time.Duration, options ...opentracing.StartSpanOption) {
+	span := tracer.StartSpan(operationName, append(options, opentracing.StartTime(suite.start))...)
+	span.FinishWithOptions(opentracing.FinishOptions{FinishTime: suite.start.Add(duration)})
+}
+
+func (suite *REDMetricsTestSuite) TestSeriesByKey() {
+	dispatcher := NewREDDispatcher(suite.memory, REDOptionsFactory.Sink(suite.sink),
+		REDOptionsFactory.Buckets([]time.Duration{100 * time.Millisecond, 10 * time.Millisecond}))
+	tracer, closer := NewTracer("my-service", dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	suite.finish(tracer, "GET /orders", 5*time.Millisecond, ext.SpanKindRPCServer)
+	suite.finish(tracer, "GET /orders", 50*time.Millisecond, ext.SpanKindRPCServer)
+	suite.finish(tracer, "GET /orders", time.Second, ext.SpanKindRPCServer, opentracing.Tag{Key: string(ext.Error), Value: true})
+	suite.finish(tracer, "compute", 10*time.Millisecond)
+
+	suite.Len(suite.memory.Spans(), 4)
+	suite.Len(suite.sink.keys, 4)
+
+	series := dispatcher.Series()
+	suite.Require().Len(series, 3)
+	suite.Equal(REDKey{ServiceName: "my-service", OperationName: "GET /orders", SpanKind: "server"}, series[0].Key)
+	suite.Equal(uint64(2), series[0].Count)
+	suite.Equal(55*time.Millisecond, series[0].Sum)
+	suite.Equal([]time.Duration{10 * time.Millisecond, 100 * time.Millisecond}, series[0].Buckets)
+	suite.Equal([]uint64{1, 1, 0}, series[0].BucketCounts)
+
+	suite.True(series[1].Key.Error)
+	suite.Equal([]uint64{0, 0, 1}, series[1].BucketCounts)
+
+	suite.Equal(REDKey{ServiceName: "my-service", OperationName: "compute"}, series[2].Key)
+	suite.Equal([]uint64{1, 0, 0}, series[2].BucketCounts)
+}
+
+func (suite *REDMetricsTestSuite) TestMaxSeries() {
+	dispatcher := NewREDDispatcher(suite.memory, REDOptionsFactory.Sink(suite.sink), REDOptionsFactory.MaxSeries(1))
+	tracer, closer := NewTracer("my-service", dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	suite.finish(tracer, "/orders/1", time.Millisecond)
+	suite.finish(tracer, "/orders/2", time.Millisecond)
+	suite.finish(tracer, "/orders/3", time.Millisecond)
+	suite.finish(tracer, "/orders/1", time.Millisecond)
+
+	series := dispatcher.Series()
+	suite.Require().Len(series, 2)
+	suite.Equal(uint64(2), series[0].Count)
+	suite.Equal(REDOverflowOperation, series[1].Key.OperationName)
+	suite.Equal(uint64(2), series[1].Count)
+	suite.Equal(uint64(2), dispatcher.OverflowCount())
+	suite.Equal(REDOverflowOperation, suite.sink.keys[2].OperationName)
+}
+
+func (suite *REDMetricsTestSuite) TestProtoSpans() {
+	dispatcher := NewREDDispatcher(suite.memory)
+	dispatcher.DispatchProtoSpan(&Span{
+		ServiceName:   "replayed-service",
+		OperationName: "publish",
+		Duration:      2000,
+		Tags:          []*Tag{ConvertToProtoTag(string(ext.SpanKind), "producer"), ConvertToProtoTag(string(ext.Error), true)},
+	})
+
+	series := dispatcher.Series()
+	suite.Require().Len(series, 1)
+	suite.Equal(REDKey{ServiceName: "replayed-service", OperationName: "publish", SpanKind: "producer", Error: true}, series[0].Key)
+	suite.Equal(2*time.Millisecond, series[0].Sum)
+	suite.Len(suite.memory.Spans(), 1)
+}
+
+func (suite *REDMetricsTestSuite) TestStatsdSink() {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer func() {
+		suite.NoError(conn.Close())
+	}()
+
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "haystack")
+	suite.Require().NoError(err)
+	defer func() {
+		suite.NoError(sink.Close())
+	}()
+
+	sink.Observe(REDKey{ServiceName: "my-service", OperationName: "GET /orders", SpanKind: "server", Error: true}, 1500*time.Microsecond)
+
+	buf := make([]byte, 1024)
+	suite.Require().NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	suite.Require().NoError(err)
+	suite.Equal("haystack.my-service.GET__orders.server.error:1.500|ms", string(buf[:n]))
+}
+
+func TestUnitREDMetricsSuite(t *testing.T) {
+	suite.Run(t, new(REDMetricsTestSuite))
+}
@@ -19,14 +19,17 @@ package haystack
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	context "golang.org/x/net/context"
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 /*RemoteClient remote client*/
@@ -36,32 +39,78 @@ type RemoteClient interface {
 	SetLogger(logger Logger)
 }
 
+/*ReportingClient is a remote client that reports the result of a send, the remote dispatchers count its failed sends in their stats while the sends of other clients are counted as successful*/
+type ReportingClient interface {
+	RemoteClient
+	TrySend(span *Span) error
+}
+
+// ClientOption is a function that sets some option on a remote client
+type ClientOption func(opts *clientOptions)
+
+type clientOptions struct {
+	tlsConfig *tls.Config
+}
+
+/*ClientOptions a list of remote client options*/
+type ClientOptions struct{}
+
+/*ClientOptionsFactory factory to create multiple remote client options*/
+var ClientOptionsFactory ClientOptions
+
+/*TLSConfig connects over tls, see NewTLSConfig. It is used as is by the http client, so an https url is still required*/
+func (c ClientOptions) TLSConfig(config *tls.Config) ClientOption {
+	return func(opts *clientOptions) {
+		opts.tlsConfig = config
+	}
+}
+
+func newClientOptions(options []ClientOption) clientOptions {
+	opts := clientOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
 /*GrpcClient grpc client*/
 type GrpcClient struct {
-	conn    *grpc.ClientConn
-	client  SpanAgentClient
-	timeout time.Duration
-	logger  Logger
+	conn      *grpc.ClientConn
+	client    SpanAgentClient
+	timeout   time.Duration
+	logger    Logger
+	tlsConfig *tls.Config
 }
 
 /*NewGrpcClient returns a new grpc client*/
-func NewGrpcClient(host string, port int, timeout time.Duration) *GrpcClient {
+func NewGrpcClient(host string, port int, timeout time.Duration, options ...ClientOption) *GrpcClient {
+	opts := newClientOptions(options)
+	transport := grpc.WithInsecure()
+	if opts.tlsConfig != nil {
+		transport = grpc.WithTransportCredentials(credentials.NewTLS(withDialHost(opts.tlsConfig, host)))
+	}
 	targetHost := fmt.Sprintf("%s:%d", host, port)
-	conn, err := grpc.Dial(targetHost, grpc.WithInsecure())
+	conn, err := grpc.Dial(targetHost, transport)
 
 	if err != nil {
 		panic(fmt.Sprintf("fail to connect to agent with error: %v", err))
 	}
 
 	return &GrpcClient{
-		conn:    conn,
-		client:  NewSpanAgentClient(conn),
-		timeout: timeout,
+		conn:      conn,
+		client:    NewSpanAgentClient(conn),
+		timeout:   timeout,
+		tlsConfig: opts.tlsConfig,
 	}
 }
 
-/*Send a proto span to grpc server*/
+/*Send a proto span to grpc server, the errors are logged*/
 func (c *GrpcClient) Send(span *Span) {
+	_ = c.TrySend(span)
+}
+
+/*TrySend sends a proto span to grpc server and returns the error, which is logged too*/
+func (c *GrpcClient) TrySend(span *Span) error {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
@@ -69,11 +118,15 @@ func (c *GrpcClient) Send(span *Span) {
 
 	if err != nil {
 		c.logger.Error("Fail to dispatch to haystack-agent with error %v", err)
-	} else if result.GetCode() != DispatchResult_SUCCESS {
-		c.logger.Error(fmt.Sprintf("Fail to dispatch to haystack-agent with error code: %d, message :%s", result.GetCode(), result.GetErrorMessage()))
-	} else {
-		c.logger.Debug(fmt.Sprintf("span [%v] has been successfully dispatched to haystack", span))
+		return err
+	}
+	if result.GetCode() != DispatchResult_SUCCESS {
+		err = fmt.Errorf("haystack-agent returned error code: %d, message :%s", result.GetCode(), result.GetErrorMessage())
+		c.logger.Error("Fail to dispatch to haystack-agent with error %v", err)
+		return err
 	}
+	c.logger.Debug("span [%v] has been successfully dispatched to haystack", span)
+	return nil
 }
 
 /*Close the grpc client*/
@@ -81,45 +134,63 @@ func (c *GrpcClient) Close() error {
 	return c.conn.Close()
 }
 
-/*SetLogger sets the logger*/
+/*SetLogger sets the logger, which logs the reload errors of the tls config too*/
 func (c *GrpcClient) SetLogger(logger Logger) {
 	c.logger = logger
+	setTLSLogger(c.tlsConfig, logger)
 }
 
 /*HTTPClient a http client*/
 type HTTPClient struct {
-	url     string
-	headers map[string]string
-	client  *http.Client
-	logger  Logger
+	url       string
+	headers   map[string]string
+	client    *http.Client
+	logger    Logger
+	tlsConfig *tls.Config
 }
 
 /*NewHTTPClient returns a new http client*/
-func NewHTTPClient(url string, headers map[string]string, timeout time.Duration) *HTTPClient {
+func NewHTTPClient(url string, headers map[string]string, timeout time.Duration, options ...ClientOption) *HTTPClient {
 	httpClient := &http.Client{
 		Timeout: timeout,
 	}
+	opts := newClientOptions(options)
+	if opts.tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		tlsConfig := opts.tlsConfig
+		if u, err := neturl.Parse(url); err == nil {
+			tlsConfig = withDialHost(tlsConfig, u.Hostname())
+		}
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
 
 	return &HTTPClient{
-		url:     url,
-		headers: headers,
-		client:  httpClient,
+		url:       url,
+		headers:   headers,
+		client:    httpClient,
+		tlsConfig: opts.tlsConfig,
 	}
 }
 
-/*Send a proto span to http server*/
+/*Send a proto span to http server, the errors are logged*/
 func (c *HTTPClient) Send(span *Span) {
+	_ = c.TrySend(span)
+}
+
+/*TrySend sends a proto span to http server and returns the error, which is logged too*/
+func (c *HTTPClient) TrySend(span *Span) error {
 	serializedBytes, marshalErr := proto.Marshal(span)
 
 	if marshalErr != nil {
 		c.logger.Error("Fail to serialize the span to proto bytes, error=%v", marshalErr)
-		return
+		return marshalErr
 	}
 
 	postRequest, requestErr := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(serializedBytes))
 	if requestErr != nil {
 		c.logger.Error("Fail to create request for posting span to haystack server, error=%v", requestErr)
-		return
+		return requestErr
 	}
 
 	if c.headers != nil {
@@ -132,6 +203,7 @@ func (c *HTTPClient) Send(span *Span) {
 
 	if err != nil {
 		c.logger.Error("Fail to dispatch to haystack http server, error=%v", err)
+		return err
 	}
 
 	defer func() {
@@ -144,14 +216,15 @@ func (c *HTTPClient) Send(span *Span) {
 	respBytes, respErr := ioutil.ReadAll(resp.Body)
 	if respErr != nil {
 		c.logger.Error("Fail to read the http response from haystack server, error=%v", respErr)
-		return
+		return respErr
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		c.logger.Error("Fail to dispatch the span to haystack http server with statusCode=%d , payload=%s", resp.StatusCode, string(respBytes))
-	} else {
-		c.logger.Debug(fmt.Sprintf("span [%v] has been successfully dispatched to haystack, response=%s", span, string(respBytes)))
+		return fmt.Errorf("fail to dispatch the span to haystack http server with statusCode=%d", resp.StatusCode)
 	}
+	c.logger.Debug("span [%v] has been successfully dispatched to haystack, response=%s", span, string(respBytes))
+	return nil
 }
 
 /*Close the http client*/
@@ -159,7 +232,8 @@ func (c *HTTPClient) Close() error {
 	return nil
 }
 
-/*SetLogger sets the logger*/
+/*SetLogger sets the logger, which logs the reload errors of the tls config too*/
 func (c *HTTPClient) SetLogger(logger Logger) {
 	c.logger = logger
+	setTLSLogger(c.tlsConfig, logger)
 }
@@ -0,0 +1,252 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is fixed width so that backups sort chronologically by name
+const backupTimeFormat = "2006-01-02T15-04-05.000000000"
+
+const compressSuffix = ".gz"
+
+/*rotatingFile is a io.WriteCloser that rolls the underlying file over based on FileDispatcherOpts*/
+type rotatingFile struct {
+	filename string
+	opts     FileDispatcherOpts
+	timeNow  func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	logger   Logger
+
+	// millMu serializes the compression and cleanup of rotated files
+	millMu sync.Mutex
+	millWg sync.WaitGroup
+}
+
+func newRotatingFile(filename string, opts FileDispatcherOpts) (*rotatingFile, error) {
+	f := &rotatingFile{
+		filename: filename,
+		opts:     opts,
+		timeNow:  time.Now,
+		logger:   NullLogger{},
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) setLogger(logger Logger) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logger = logger
+}
+
+func (f *rotatingFile) open() error {
+	fd, err := os.OpenFile(f.filename, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return err
+	}
+	f.file = fd
+	f.size = info.Size()
+	f.openedAt = f.timeNow()
+	return nil
+}
+
+/*Write writes the data to the current file, rotating it first if required*/
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.shouldRotate(int64(len(p))) {
+		f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) shouldRotate(writeLen int64) bool {
+	// never rotate an empty file, a single oversized record has to go somewhere
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSizeBytes > 0 && f.size+writeLen > f.opts.MaxSizeBytes {
+		return true
+	}
+	if f.opts.MaxAge > 0 && f.timeNow().Sub(f.openedAt) >= f.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+// rotate moves the current file to a backup and opens a new one. A failed rotation is logged and the records keep
+// going to the current file, so that no span is lost
+func (f *rotatingFile) rotate() {
+	backup := f.filename + "." + f.timeNow().UTC().Format(backupTimeFormat)
+	if err := os.Rename(f.filename, backup); err != nil {
+		f.logger.Error("Fail to rotate the span file %s, error=%v", f.filename, err)
+		f.postponeRotation()
+		return
+	}
+	current := f.file
+	if err := f.open(); err != nil {
+		f.logger.Error("Fail to open the span file %s after rotating it, error=%v", f.filename, err)
+		if err := os.Rename(backup, f.filename); err != nil {
+			f.logger.Error("Fail to restore the span file %s, error=%v", f.filename, err)
+		}
+		f.postponeRotation()
+		return
+	}
+	if err := current.Close(); err != nil {
+		f.logger.Error("Fail to close the rotated span file %s, error=%v", backup, err)
+	}
+
+	logger := f.logger
+	f.millWg.Add(1)
+	go func() {
+		defer f.millWg.Done()
+		f.mill(backup, logger)
+	}()
+}
+
+// postponeRotation tries the rotation again only once the current file has grown by MaxSizeBytes or aged by MaxAge,
+// rather than on every write
+func (f *rotatingFile) postponeRotation() {
+	f.size = 0
+	f.openedAt = f.timeNow()
+}
+
+// mill compresses the freshly rotated backup and removes the backups exceeding MaxBackups
+func (f *rotatingFile) mill(backup string, logger Logger) {
+	f.millMu.Lock()
+	defer f.millMu.Unlock()
+
+	if f.opts.Compress {
+		if err := compressFile(backup); err != nil {
+			logger.Error("Fail to compress the rotated span file %s, error=%v", backup, err)
+		}
+	}
+
+	if f.opts.MaxBackups <= 0 {
+		return
+	}
+	backups, err := f.backups()
+	if err != nil {
+		logger.Error("Fail to list the rotated span files of %s, error=%v", f.filename, err)
+		return
+	}
+	for len(backups) > f.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			logger.Error("Fail to remove the rotated span file %s, error=%v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns the rotated files of this file, oldest first
+func (f *rotatingFile) backups() ([]string, error) {
+	dir := filepath.Dir(f.filename)
+	prefix := filepath.Base(f.filename) + "."
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(name[len(prefix):], compressSuffix)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func compressFile(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := os.OpenFile(filename+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(filename + compressSuffix)
+		return fmt.Errorf("fail to gzip %s: %v", filename, err)
+	}
+	return os.Remove(filename)
+}
+
+/*Close closes the current file and waits for pending compression and cleanup*/
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+
+	f.millWg.Wait()
+	return err
+}
@@ -0,0 +1,182 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RotatingFileTestSuite struct {
+	suite.Suite
+	dir      string
+	filename string
+}
+
+func (suite *RotatingFileTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "haystack-spans")
+	suite.Require().NoError(err)
+	suite.dir = dir
+	suite.filename = filepath.Join(dir, "spans.log")
+}
+
+func (suite *RotatingFileTestSuite) TearDownTest() {
+	suite.Require().NoError(os.RemoveAll(suite.dir))
+}
+
+func (suite *RotatingFileTestSuite) TestNoRotationWithoutLimits() {
+	f, err := newRotatingFile(suite.filename, FileDispatcherOpts{})
+	suite.Require().NoError(err)
+	for i := 0; i < 100; i++ {
+		_, err = f.Write([]byte("0123456789\n"))
+		suite.Require().NoError(err)
+	}
+	suite.Require().NoError(f.Close())
+
+	backups, err := f.backups()
+	suite.Require().NoError(err)
+	suite.Empty(backups)
+	suite.Equal(100, suite.countLines(suite.filename))
+}
+
+func (suite *RotatingFileTestSuite) TestRotatesOnSizeAndKeepsMaxBackups() {
+	f, err := newRotatingFile(suite.filename, FileDispatcherOpts{MaxSizeBytes: 22, MaxBackups: 2})
+	suite.Require().NoError(err)
+	for i := 0; i < 10; i++ {
+		_, err = f.Write([]byte("0123456789\n"))
+		suite.Require().NoError(err)
+	}
+	suite.Require().NoError(f.Close())
+
+	backups, err := f.backups()
+	suite.Require().NoError(err)
+	suite.Len(backups, 2, "only the configured number of backups should be retained")
+	for _, backup := range backups {
+		suite.Equal(2, suite.countLines(backup))
+	}
+	suite.Equal(2, suite.countLines(suite.filename))
+}
+
+func (suite *RotatingFileTestSuite) TestRotatesOnAge() {
+	now := time.Now()
+	f, err := newRotatingFile(suite.filename, FileDispatcherOpts{MaxAge: time.Minute})
+	suite.Require().NoError(err)
+	f.timeNow = func() time.Time { return now }
+
+	_, err = f.Write([]byte("first\n"))
+	suite.Require().NoError(err)
+	now = now.Add(2 * time.Minute)
+	_, err = f.Write([]byte("second\n"))
+	suite.Require().NoError(err)
+	suite.Require().NoError(f.Close())
+
+	backups, err := f.backups()
+	suite.Require().NoError(err)
+	suite.Len(backups, 1)
+	suite.Equal(1, suite.countLines(backups[0]))
+	suite.Equal(1, suite.countLines(suite.filename))
+}
+
+func (suite *RotatingFileTestSuite) TestCompressesBackupsUnderConcurrentWrites() {
+	f, err := newRotatingFile(suite.filename, FileDispatcherOpts{MaxSizeBytes: 1024, Compress: true})
+	suite.Require().NoError(err)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, writeErr := f.Write([]byte("0123456789\n"))
+				suite.NoError(writeErr)
+			}
+		}()
+	}
+	wg.Wait()
+	suite.Require().NoError(f.Close())
+
+	backups, err := f.backups()
+	suite.Require().NoError(err)
+	suite.NotEmpty(backups)
+
+	total := suite.countLines(suite.filename)
+	for _, backup := range backups {
+		suite.True(strings.HasSuffix(backup, compressSuffix), "rotated file %s should be compressed", backup)
+		total += suite.countLines(backup)
+	}
+	suite.Equal(8*200, total, "no record should be lost during rotation")
+}
+
+func (suite *RotatingFileTestSuite) TestFailedRotationKeepsWritingToTheCurrentFile() {
+	now := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	f, err := newRotatingFile(suite.filename, FileDispatcherOpts{MaxSizeBytes: 22})
+	suite.Require().NoError(err)
+	f.timeNow = func() time.Time { return now }
+	var logs bytes.Buffer
+	f.setLogger(NewStdLogger(log.New(&logs, "", 0)))
+
+	// a directory in the way of the backup makes the rename fail
+	suite.Require().NoError(os.Mkdir(suite.filename+"."+now.Format(backupTimeFormat), 0755))
+	for i := 0; i < 4; i++ {
+		_, err = f.Write([]byte("0123456789\n"))
+		suite.Require().NoError(err)
+	}
+	suite.Require().NoError(f.Close())
+
+	suite.Equal(4, suite.countLines(suite.filename), "no record should be lost")
+	suite.Equal(1, strings.Count(logs.String(), "ERROR Fail to rotate the span file"), "the rotation should only be tried again after MaxSizeBytes, got %s", logs.String())
+}
+
+func (suite *RotatingFileTestSuite) countLines(filename string) int {
+	fd, err := os.Open(filename)
+	suite.Require().NoError(err)
+	defer func() {
+		_ = fd.Close()
+	}()
+
+	var reader io.Reader = fd
+	if strings.HasSuffix(filename, compressSuffix) {
+		gz, err := gzip.NewReader(fd)
+		suite.Require().NoError(err)
+		reader = gz
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lines++
+	}
+	suite.Require().NoError(scanner.Err())
+	return lines
+}
+
+func TestUnitRotatingFileSuite(t *testing.T) {
+	suite.Run(t, new(RotatingFileTestSuite))
+}
@@ -0,0 +1,89 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+/*SamplingDispatcher forwards the spans of a fraction of the traces. The decision is made on the trace id, so every span of a trace is kept or dropped together, in all the services sampling at the same rate*/
+type SamplingDispatcher struct {
+	next      Dispatcher
+	rate      float64
+	threshold uint64
+}
+
+/*NewSamplingDispatcher wraps the dispatcher so that it receives the spans of the given fraction of the traces, between 0 and 1*/
+func NewSamplingDispatcher(next Dispatcher, rate float64) *SamplingDispatcher {
+	d := &SamplingDispatcher{next: next, rate: rate}
+	if rate < 1 && rate > 0 {
+		d.threshold = uint64(rate * math.MaxUint64)
+	}
+	return d
+}
+
+/*Name gives the Dispatcher name*/
+func (d *SamplingDispatcher) Name() string {
+	return "SamplingDispatcher(" + d.next.Name() + ")"
+}
+
+/*SetLogger sets the logger to use*/
+func (d *SamplingDispatcher) SetLogger(logger Logger) {
+	d.next.SetLogger(logger)
+}
+
+/*Dispatch dispatches the span if its trace is sampled*/
+func (d *SamplingDispatcher) Dispatch(span FinishedSpan) {
+	if d.Sampled(finishedSpanContext(span).TraceID) {
+		d.next.Dispatch(span)
+	}
+}
+
+/*DispatchProtoSpan dispatches the proto span if its trace is sampled*/
+func (d *SamplingDispatcher) DispatchProtoSpan(span *Span) {
+	if d.Sampled(span.GetTraceId()) {
+		d.next.DispatchProtoSpan(span)
+	}
+}
+
+/*Close closes the next dispatcher*/
+func (d *SamplingDispatcher) Close() {
+	d.next.Close()
+}
+
+/*Stats returns the self metrics of the next dispatcher if it reports them*/
+func (d *SamplingDispatcher) Stats() Stats {
+	if reporter, ok := d.next.(StatsReporter); ok {
+		return reporter.Stats()
+	}
+	return Stats{}
+}
+
+/*Sampled tells whether the spans of the trace are dispatched*/
+func (d *SamplingDispatcher) Sampled(traceID string) bool {
+	if d.rate >= 1 {
+		return true
+	}
+	if d.rate <= 0 {
+		return false
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(traceID))
+	return hash.Sum64() < d.threshold
+}
@@ -0,0 +1,66 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type SamplingDispatcherTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SamplingDispatcherTestSuite) TestSamplesWholeTraces() {
+	memory := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	tracer, closer := NewTracer("my-service", NewSamplingDispatcher(memory, 0.3))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	for i := 0; i < 1000; i++ {
+		root := tracer.StartSpan("root")
+		tracer.StartSpan("child", opentracing.ChildOf(root.Context())).Finish()
+		root.Finish()
+	}
+
+	spans := memory.Spans()
+	suite.InDelta(600, len(spans), 100)
+	for _, span := range spans {
+		suite.Len(memory.SpansByTraceID(span.GetTraceId()), 2)
+	}
+}
+
+func (suite *SamplingDispatcherTestSuite) TestBounds() {
+	never := NewSamplingDispatcher(NewInMemoryDispatcher(), 0)
+	always := NewSamplingDispatcher(NewInMemoryDispatcher(), 1)
+	half := NewSamplingDispatcher(NewInMemoryDispatcher(), 0.5)
+	for i := 0; i < 100; i++ {
+		traceID := strconv.Itoa(i)
+		suite.False(never.Sampled(traceID))
+		suite.True(always.Sampled(traceID))
+		suite.Equal(half.Sampled(traceID), half.Sampled(traceID))
+	}
+}
+
+func TestUnitSamplingDispatcherSuite(t *testing.T) {
+	suite.Run(t, new(SamplingDispatcherTestSuite))
+}
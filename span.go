@@ -25,7 +25,27 @@ import (
 	"github.com/opentracing/opentracing-go/log"
 )
 
-/*_Span implements opentracing.Span*/
+/*FinishedSpan is a read only view of a finished span, as handed to the dispatchers*/
+type FinishedSpan interface {
+	// Context returns the *SpanContext of the span
+	Context() opentracing.SpanContext
+	OperationName() string
+	ServiceName() string
+	StartTime() time.Time
+	Duration() time.Duration
+	Tags() []opentracing.Tag
+	Logs() []opentracing.LogRecord
+}
+
+// finishedSpanContext returns the haystack context of the span, an empty one if it is of another tracer
+func finishedSpanContext(span FinishedSpan) *SpanContext {
+	if spanContext, ok := span.Context().(*SpanContext); ok {
+		return spanContext
+	}
+	return &SpanContext{}
+}
+
+/*_Span implements opentracing.Span and FinishedSpan*/
 type _Span struct {
 	tracer  *Tracer
 	context *SpanContext
@@ -37,8 +57,19 @@ type _Span struct {
 	// duration returns duration of the span with microseconds precision.
 	duration time.Duration
 
-	tags []opentracing.Tag
-	logs []opentracing.LogRecord
+	// tags keeps the insertion order, tagIndex maps a key to its position in tags
+	tags     []opentracing.Tag
+	tagIndex map[string]int
+	logs     []opentracing.LogRecord
+
+	// references are all the references the span started with, the parent included
+	references []opentracing.SpanReference
+
+	// drops counts the items lost to the span limits of the tracer
+	drops spanDrops
+
+	// detached is set once the span is handed over to a SpanHandle, which finishes it instead
+	detached bool
 }
 
 // SetOperationName sets or changes the operation name.
@@ -47,23 +78,71 @@ func (span *_Span) SetOperationName(operationName string) opentracing.Span {
 	return span
 }
 
-// SetTag implements SetTag() of opentracing.Span
+// SetTag implements SetTag() of opentracing.Span, setting a key again overwrites its value in place
+// unless the tracer keeps multi-value tags
 func (span *_Span) SetTag(key string, value interface{}) opentracing.Span {
-	span.tags = append(span.tags,
-		opentracing.Tag{
-			Key:   key,
-			Value: value,
-		})
+	limits := &span.tracer.limits
+	_, exists := span.tagIndex[key]
+	if limits.MaxTags > 0 && len(span.tags) >= limits.MaxTags && (!exists || span.tracer.multiValueTags) {
+		span.drops.tags++
+		return span
+	}
+	if limited, truncated := limits.limitValue(value); truncated {
+		value = limited
+		span.drops.truncatedValues++
+	}
+	span.putTag(key, value)
 	return span
 }
 
+// putTag overwrites the value of an existing key or appends a new tag, it does not enforce the span limits
+func (span *_Span) putTag(key string, value interface{}) {
+	if span.tracer.multiValueTags {
+		span.tags = append(span.tags, opentracing.Tag{Key: key, Value: value})
+		return
+	}
+	if i, ok := span.tagIndex[key]; ok {
+		span.tags[i].Value = value
+		return
+	}
+	if span.tagIndex == nil {
+		span.tagIndex = make(map[string]int)
+	}
+	span.tagIndex[key] = len(span.tags)
+	span.tags = append(span.tags, opentracing.Tag{Key: key, Value: value})
+}
+
 // LogFields implements opentracing.Span API
 func (span *_Span) LogFields(fields ...log.Field) {
-	log := opentracing.LogRecord{
+	span.appendLog(opentracing.LogRecord{
 		Fields:    fields,
-		Timestamp: time.Now(),
+		Timestamp: span.tracer.timeNow(),
+	})
+}
+
+// appendLog adds the log record within the span limits of the tracer
+func (span *_Span) appendLog(record opentracing.LogRecord) {
+	limits := &span.tracer.limits
+	if limits.MaxLogs > 0 && len(span.logs) >= limits.MaxLogs {
+		span.drops.logs++
+		return
 	}
-	span.logs = append(span.logs, log)
+	if limits.MaxFieldsPerLog > 0 && len(record.Fields) > limits.MaxFieldsPerLog {
+		span.drops.logFields += len(record.Fields) - limits.MaxFieldsPerLog
+		record.Fields = record.Fields[:limits.MaxFieldsPerLog]
+	}
+	if limits.MaxStringLength > 0 || limits.MaxBinaryLength > 0 {
+		fields := make([]log.Field, len(record.Fields))
+		for i, field := range record.Fields {
+			limited, truncated := limits.limitField(field)
+			if truncated {
+				span.drops.truncatedValues++
+			}
+			fields[i] = limited
+		}
+		record.Fields = fields
+	}
+	span.logs = append(span.logs, record)
 }
 
 // LogKV implements opentracing.Span API
@@ -88,13 +167,28 @@ func (span *_Span) LogEventWithPayload(event string, payload interface{}) {
 
 // Log implements opentracing.Span API
 func (span *_Span) Log(ld opentracing.LogData) {
-	span.logs = append(span.logs, ld.ToLogRecord())
+	if ld.Timestamp.IsZero() {
+		ld.Timestamp = span.tracer.timeNow()
+	}
+	span.appendLog(ld.ToLogRecord())
 }
 
 // SetBaggageItem implements SetBaggageItem() of opentracing.SpanContext
 func (span *_Span) SetBaggageItem(key, value string) opentracing.Span {
+	if max := span.tracer.limits.MaxBaggageBytes; max > 0 {
+		size := baggageBytes(span.context.Baggage) + len(key) + len(value)
+		if previous, ok := span.context.Baggage[key]; ok {
+			size -= len(key) + len(previous)
+		}
+		if size > max {
+			span.drops.baggage++
+			return span
+		}
+	}
 	span.context = span.context.WithBaggageItem(key, value)
-	span.LogFields(log.String("event", "baggage"), log.String("payload", key), log.String("payload", value))
+	if !span.tracer.disableBaggageLogging {
+		span.LogFields(log.String("event", "baggage"), log.String("key", key), log.String("value", value))
+	}
 	return span
 }
 
@@ -110,19 +204,53 @@ func (span *_Span) Finish() {
 
 // FinishWithOptions implements opentracing.Span API
 func (span *_Span) FinishWithOptions(options opentracing.FinishOptions) {
+	if span.detached {
+		return
+	}
 	if options.FinishTime.IsZero() {
 		options.FinishTime = span.tracer.timeNow()
 	}
+	// Sub uses the monotonic clock readings when both times come from the tracer clock,
+	// so the duration is not affected by wall clock adjustments during the span
 	span.duration = options.FinishTime.Sub(span.startTime)
-	if options.LogRecords != nil {
-		span.logs = append(span.logs, options.LogRecords...)
+	for _, lr := range options.LogRecords {
+		if lr.Timestamp.IsZero() {
+			lr.Timestamp = options.FinishTime
+		}
+		span.appendLog(lr)
 	}
 	for _, ld := range options.BulkLogData {
-		span.logs = append(span.logs, ld.ToLogRecord())
+		if ld.Timestamp.IsZero() {
+			ld.Timestamp = options.FinishTime
+		}
+		span.appendLog(ld.ToLogRecord())
 	}
+	span.tagDrops()
 	span.tracer.DispatchSpan(span)
 }
 
+// tagDrops adds the indicator tags of the items lost to the span limits, they are not subject to MaxTags
+func (span *_Span) tagDrops() {
+	if span.drops == (spanDrops{}) {
+		return
+	}
+	span.tracer.recordDrops(span.drops)
+	for _, indicator := range []struct {
+		key   string
+		count int
+	}{
+		{DroppedTagsTagKey, span.drops.tags},
+		{DroppedLogsTagKey, span.drops.logs},
+		{DroppedLogFieldsTagKey, span.drops.logFields},
+		{TruncatedValuesTagKey, span.drops.truncatedValues},
+		{DroppedBaggageTagKey, span.drops.baggage},
+	} {
+		if indicator.count > 0 {
+			span.putTag(indicator.key, indicator.count)
+		}
+	}
+}
+
 // Context implements opentracing.Span API
 func (span *_Span) Context() opentracing.SpanContext {
 	return span.context
@@ -143,22 +271,48 @@ func (span *_Span) ServiceName() string {
 	return span.tracer.serviceName
 }
 
+/*StartTime returns the time the span started*/
+func (span *_Span) StartTime() time.Time {
+	return span.startTime
+}
+
+/*Duration returns the duration of the span, zero until the span is finished*/
+func (span *_Span) Duration() time.Duration {
+	return span.duration
+}
+
+/*References returns all the references the span started with, including the one to its parent*/
+func (span *_Span) References() []opentracing.SpanReference {
+	return span.references
+}
+
+/*Tags returns the tags of the span*/
+func (span *_Span) Tags() []opentracing.Tag {
+	return span.tags
+}
+
+/*Logs returns the logs of the span*/
+func (span *_Span) Logs() []opentracing.LogRecord {
+	return span.logs
+}
+
 func (span *_Span) String() string {
-	data, err := json.Marshal(map[string]interface{}{
-		"traceId":       span.context.TraceID,
-		"spanId":        span.context.SpanID,
-		"parentSpanId":  span.context.ParentID,
-		"operationName": span.OperationName(),
-		"serviceName":   span.ServiceName(),
-		"tags":          span.Tags(),
-		"logs":          span.logs,
-	})
+	data, err := marshalSpanJSON(span)
 	if err != nil {
 		panic(err)
 	}
 	return string(data)
 }
 
-func (span *_Span) Tags() []opentracing.Tag {
-	return span.tags
+func marshalSpanJSON(span FinishedSpan) ([]byte, error) {
+	spanContext := finishedSpanContext(span)
+	return json.Marshal(map[string]interface{}{
+		"traceId":       spanContext.TraceID,
+		"spanId":        spanContext.SpanID,
+		"parentSpanId":  spanContext.ParentID,
+		"operationName": span.OperationName(),
+		"serviceName":   span.ServiceName(),
+		"tags":          span.Tags(),
+		"logs":          span.Logs(),
+	})
 }
@@ -54,7 +54,7 @@ func (context SpanContext) ForeachBaggageItem(handler func(k, v string) bool) {
 	}
 }
 
-// WithBaggageItem creates a new context with an extra baggage item.
+// WithBaggageItem creates a copy of the context, keeping all its fields, with an extra baggage item.
 func (context SpanContext) WithBaggageItem(key, value string) *SpanContext {
 	var newBaggage map[string]string
 	if context.Baggage == nil {
@@ -66,12 +66,8 @@ func (context SpanContext) WithBaggageItem(key, value string) *SpanContext {
 		}
 		newBaggage[key] = value
 	}
-	return &SpanContext{
-		TraceID:  context.TraceID,
-		SpanID:   context.SpanID,
-		ParentID: context.ParentID,
-		Baggage:  newBaggage,
-	}
+	context.Baggage = newBaggage
+	return &context
 }
 
 /*ToString represents the string*/
@@ -0,0 +1,158 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	// ErrorKindLogField is the log field holding the go type of the error, or of the panic value
+	ErrorKindLogField = "error.kind"
+
+	// MessageLogField is the log field holding the error message
+	MessageLogField = "message"
+
+	// StackLogField is the log field holding the stack trace where the error was recorded or the panic happened
+	StackLogField = "stack"
+
+	// ErrorChainLogField is the log field holding the wrapped errors, one "type: message" line per errors.Unwrap level
+	ErrorChainLogField = "error.chain"
+
+	maxStackDepth = 32
+)
+
+/*ErrorOption is a function that sets some option on the recording of an error*/
+type ErrorOption func(opts *errorOptions)
+
+type errorOptions struct {
+	stack bool
+	chain bool
+}
+
+/*ErrorOptions a list of error recording options*/
+type ErrorOptions struct{}
+
+/*ErrorOptionsFactory factory to create multiple error recording options*/
+var ErrorOptionsFactory ErrorOptions
+
+/*Stack records the stack trace in the stack log field*/
+func (e ErrorOptions) Stack() ErrorOption {
+	return func(opts *errorOptions) {
+		opts.stack = true
+	}
+}
+
+/*Chain records the errors wrapped by the error in the error.chain log field*/
+func (e ErrorOptions) Chain() ErrorOption {
+	return func(opts *errorOptions) {
+		opts.chain = true
+	}
+}
+
+/*SetError marks the span as failed with the error=true tag and logs an error event with the error.kind, error.object and message fields, a nil error is ignored*/
+func SetError(span opentracing.Span, err error, options ...ErrorOption) {
+	if err == nil {
+		return
+	}
+	recordError(span, err, options, 4)
+}
+
+/*FinishWithRecover finishes the span, to be deferred in place of span.Finish(). If the function panics the panic value is recorded as with SetError before the panic goes on*/
+func FinishWithRecover(span opentracing.Span, options ...ErrorOption) {
+	if r := recover(); r != nil {
+		err, ok := r.(error)
+		if !ok {
+			err = &panicError{value: r}
+		}
+		// the stack of the deferred call still holds the frames of the panicking function, below runtime.gopanic
+		recordError(span, err, options, 5)
+		span.Finish()
+		panic(r)
+	}
+	span.Finish()
+}
+
+// panicError wraps a panic value that is not an error
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprint(e.value)
+}
+
+func recordError(span opentracing.Span, err error, options []ErrorOption, skip int) {
+	opts := errorOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+
+	kind := fmt.Sprintf("%T", err)
+	if p, ok := err.(*panicError); ok {
+		kind = fmt.Sprintf("%T", p.value)
+	}
+	fields := []log.Field{
+		log.String("event", "error"),
+		log.String(ErrorKindLogField, kind),
+		log.Error(err),
+		log.String(MessageLogField, err.Error()),
+	}
+	if opts.chain {
+		if chain := errorChain(err); chain != "" {
+			fields = append(fields, log.String(ErrorChainLogField, chain))
+		}
+	}
+	if opts.stack {
+		fields = append(fields, log.String(StackLogField, stackTrace(skip)))
+	}
+
+	ext.Error.Set(span, true)
+	span.LogFields(fields...)
+}
+
+// errorChain lists the errors wrapped by err, err itself excluded
+func errorChain(err error) string {
+	var lines []string
+	for cause := errors.Unwrap(err); cause != nil; cause = errors.Unwrap(cause) {
+		lines = append(lines, fmt.Sprintf("%T: %s", cause, cause.Error()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stackTrace formats the stack above the skip innermost frames, runtime.Callers being the first one
+func stackTrace(skip int) string {
+	pcs := make([]uintptr, maxStackDepth)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(skip, pcs)])
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,117 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpanErrorsTestSuite struct {
+	suite.Suite
+	dispatcher *InMemoryDispatcher
+	tracer     opentracing.Tracer
+	closer     io.Closer
+}
+
+func (suite *SpanErrorsTestSuite) SetupTest() {
+	suite.dispatcher = NewInMemoryDispatcher().(*InMemoryDispatcher)
+	suite.tracer, suite.closer = NewTracer("my-service", suite.dispatcher)
+}
+
+func (suite *SpanErrorsTestSuite) TearDownTest() {
+	suite.NoError(suite.closer.Close())
+}
+
+func (suite *SpanErrorsTestSuite) record(operationName string) *SpanRecord {
+	spans := suite.dispatcher.SpansByOperation(operationName)
+	suite.Require().Len(spans, 1)
+	return NewSpanRecord(spans[0])
+}
+
+func (suite *SpanErrorsTestSuite) errorFields(record *SpanRecord) map[string]interface{} {
+	suite.Require().Len(record.Logs(), 1)
+	fields := make(map[string]interface{})
+	for _, field := range record.Logs()[0].Fields {
+		fields[field.Key()] = field.Value()
+	}
+	return fields
+}
+
+func (suite *SpanErrorsTestSuite) TestSetError() {
+	span := suite.tracer.StartSpan("op")
+	err := fmt.Errorf("load config: %w", &os.PathError{Op: "open", Path: "haystack.yaml", Err: os.ErrNotExist})
+	SetError(span, err, ErrorOptionsFactory.Stack(), ErrorOptionsFactory.Chain())
+	SetError(span, nil)
+	span.Finish()
+
+	record := suite.record("op")
+	value, _ := record.Tag("error")
+	suite.Equal(true, value)
+
+	fields := suite.errorFields(record)
+	suite.Equal("error", fields["event"])
+	suite.Equal("*fmt.wrapError", fields[ErrorKindLogField])
+	suite.Equal("load config: open haystack.yaml: file does not exist", fields["error.object"])
+	suite.Equal("load config: open haystack.yaml: file does not exist", fields[MessageLogField])
+	suite.Equal("*fs.PathError: open haystack.yaml: file does not exist\n*errors.errorString: file does not exist", fields[ErrorChainLogField])
+	suite.Contains(fields[StackLogField], "haystack-client-go.(*SpanErrorsTestSuite).TestSetError")
+	suite.NotContains(fields[StackLogField], "haystack-client-go.SetError")
+}
+
+func (suite *SpanErrorsTestSuite) TestSetErrorWithoutOptions() {
+	span := suite.tracer.StartSpan("op")
+	SetError(span, errors.New("timeout"))
+	span.Finish()
+
+	fields := suite.errorFields(suite.record("op"))
+	suite.Equal("*errors.errorString", fields[ErrorKindLogField])
+	suite.NotContains(fields, StackLogField)
+	suite.NotContains(fields, ErrorChainLogField)
+}
+
+func (suite *SpanErrorsTestSuite) TestFinishWithRecover() {
+	panicking := func() {
+		span := suite.tracer.StartSpan("panicking")
+		defer FinishWithRecover(span, ErrorOptionsFactory.Stack())
+		panic("boom")
+	}
+	suite.PanicsWithValue("boom", panicking)
+
+	fields := suite.errorFields(suite.record("panicking"))
+	suite.Equal("string", fields[ErrorKindLogField])
+	suite.Equal("boom", fields[MessageLogField])
+	suite.Contains(fields[StackLogField], "TestFinishWithRecover.func1")
+
+	func() {
+		span := suite.tracer.StartSpan("succeeding")
+		defer FinishWithRecover(span)
+	}()
+	_, failed := suite.record("succeeding").Tag("error")
+	suite.False(failed)
+}
+
+func TestUnitSpanErrorsSuite(t *testing.T) {
+	suite.Run(t, new(SpanErrorsTestSuite))
+}
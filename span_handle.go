@@ -0,0 +1,123 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/opentracing/opentracing-go"
+)
+
+/*SpanHandle is an unfinished span detached from its tracer, that can be encoded to bytes, e.g. to be stored with a job, and finished later by another goroutine or process. Its tags and logs are the ones of the span when it was detached, within the SpanLimits of the tracer. The tags set on the handle and the logs added when it finishes are not limited*/
+type SpanHandle struct {
+	span     *Span
+	finished int32
+}
+
+/*DetachSpan creates the handle of an unfinished haystack span, the span itself can no longer be finished, its Finish is a no-op. The tracer counts the span as detached, not finished*/
+func DetachSpan(span opentracing.Span) (*SpanHandle, error) {
+	s, ok := span.(*_Span)
+	if !ok {
+		return nil, errors.New("not a haystack span")
+	}
+	if s.detached {
+		return nil, errors.New("span is already detached")
+	}
+	s.detached = true
+	s.tagDrops()
+	// the handle may be finished by another process, so the tracer stats only see the span detached
+	atomic.AddInt64(&s.tracer.spansDetached, 1)
+	return &SpanHandle{span: toProtoSpan(s)}, nil
+}
+
+/*UnmarshalSpanHandle decodes a handle encoded by Marshal*/
+func UnmarshalSpanHandle(data []byte) (*SpanHandle, error) {
+	span := &Span{}
+	if err := proto.Unmarshal(data, span); err != nil {
+		return nil, err
+	}
+	if span.GetTraceId() == "" || span.GetSpanId() == "" {
+		return nil, errors.New("span handle without trace and span ids")
+	}
+	return &SpanHandle{span: span}, nil
+}
+
+/*Marshal encodes the handle as the haystack proto span without duration*/
+func (h *SpanHandle) Marshal() ([]byte, error) {
+	return proto.Marshal(h.span)
+}
+
+/*Context returns the context of the span, without its baggage, e.g. to start child spans before finishing it*/
+func (h *SpanHandle) Context() opentracing.SpanContext {
+	return &SpanContext{
+		TraceID:  h.span.GetTraceId(),
+		SpanID:   h.span.GetSpanId(),
+		ParentID: h.span.GetParentSpanId(),
+	}
+}
+
+/*StartTime returns the original start time of the span*/
+func (h *SpanHandle) StartTime() time.Time {
+	return fromMicros(h.span.GetStartTime())
+}
+
+/*Finish finishes the span at the current time of the clock, e.g. SystemClock*/
+func (h *SpanHandle) Finish(dispatcher Dispatcher, clock Clock) {
+	h.FinishWithOptions(dispatcher, clock, opentracing.FinishOptions{})
+}
+
+/*SetTag sets a tag of the span, overwriting the value of an existing key. It is not safe for concurrent use*/
+func (h *SpanHandle) SetTag(key string, value interface{}) *SpanHandle {
+	tag := ConvertToProtoTag(key, value)
+	for i, existing := range h.span.Tags {
+		if existing.GetKey() == key {
+			h.span.Tags[i] = tag
+			return h
+		}
+	}
+	h.span.Tags = append(h.span.Tags, tag)
+	return h
+}
+
+/*FinishWithOptions sets the duration of the span from its original start time, adds the logs and dispatches it as a proto span. The clock gives the finish time if the options have none. Only the first call of a handle dispatches the span*/
+func (h *SpanHandle) FinishWithOptions(dispatcher Dispatcher, clock Clock, options opentracing.FinishOptions) {
+	if !atomic.CompareAndSwapInt32(&h.finished, 0, 1) {
+		return
+	}
+	if options.FinishTime.IsZero() {
+		options.FinishTime = clock.Now()
+	}
+	span := proto.Clone(h.span).(*Span)
+	span.Duration = toMicros(options.FinishTime) - span.GetStartTime()
+	for _, lr := range options.LogRecords {
+		if lr.Timestamp.IsZero() {
+			lr.Timestamp = options.FinishTime
+		}
+		span.Logs = append(span.Logs, protoLogs([]opentracing.LogRecord{lr})...)
+	}
+	for _, ld := range options.BulkLogData {
+		if ld.Timestamp.IsZero() {
+			ld.Timestamp = options.FinishTime
+		}
+		span.Logs = append(span.Logs, protoLogs([]opentracing.LogRecord{ld.ToLogRecord()})...)
+	}
+	dispatcher.DispatchProtoSpan(span)
+}
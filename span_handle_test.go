@@ -0,0 +1,135 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpanHandleTestSuite struct {
+	suite.Suite
+	start  time.Time
+	tracer opentracing.Tracer
+}
+
+func (suite *SpanHandleTestSuite) SetupTest() {
+	suite.start = time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return suite.start })
+	suite.tracer, _ = NewTracer("producer-service", NewInMemoryDispatcher(), TracerOptionsFactory.Clock(clock))
+}
+
+func (suite *SpanHandleTestSuite) TearDownTest() {
+	suite.NoError(suite.tracer.(*Tracer).Close())
+}
+
+func (suite *SpanHandleTestSuite) TestHandleIsFinishedByAnotherProcess() {
+	span := suite.tracer.StartSpan("job", opentracing.Tag{Key: "job.id", Value: "42"})
+	span.LogFields(log.String("event", "enqueued"))
+	handle, err := DetachSpan(span)
+	suite.Require().NoError(err)
+	span.Finish()
+	suite.Empty(suite.tracer.(*Tracer).dispatcher.(*InMemoryDispatcher).Spans(), "a detached span is finished by its handle")
+
+	data, err := handle.Marshal()
+	suite.Require().NoError(err)
+
+	// the worker process only has the bytes and a dispatcher
+	worker := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	restored, err := UnmarshalSpanHandle(data)
+	suite.Require().NoError(err)
+	suite.Equal(span.Context().(*SpanContext).SpanID, restored.Context().(*SpanContext).SpanID)
+	suite.Equal(suite.start, restored.StartTime().UTC())
+
+	restored.SetTag("job.id", "43").SetTag("error", true)
+	restored.FinishWithOptions(worker, SystemClock, opentracing.FinishOptions{
+		FinishTime: suite.start.Add(90 * time.Second),
+		LogRecords: []opentracing.LogRecord{{Fields: []log.Field{log.String("event", "done")}}},
+	})
+	restored.Finish(worker, SystemClock)
+
+	suite.Require().Len(worker.Spans(), 1)
+	record := NewSpanRecord(worker.Spans()[0])
+	suite.Equal("producer-service", record.ServiceName())
+	suite.Equal("job", record.OperationName())
+	suite.Equal(suite.start, record.StartTime().UTC())
+	suite.Equal(90*time.Second, record.Duration())
+	jobID, _ := record.Tag("job.id")
+	suite.Equal("43", jobID)
+	failed, _ := record.Tag("error")
+	suite.Equal(true, failed)
+	suite.Require().Len(record.Logs(), 2)
+	suite.Equal(suite.start.Add(90*time.Second), record.Logs()[1].Timestamp.UTC())
+}
+
+func (suite *SpanHandleTestSuite) TestFinishTimeComesFromTheClock() {
+	span := suite.tracer.StartSpan("job")
+	handle, err := DetachSpan(span)
+	suite.Require().NoError(err)
+
+	span.Finish()
+	stats := suite.tracer.(*Tracer).Stats()
+	suite.Equal(int64(1), stats.SpansDetached)
+	suite.Equal(int64(0), stats.SpansFinished, "a detached span should not be counted as finished")
+
+	dispatcher := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	handle.Finish(dispatcher, ClockFunc(func() time.Time { return suite.start.Add(time.Minute) }))
+	suite.Require().Len(dispatcher.Spans(), 1)
+	suite.Equal(time.Minute, NewSpanRecord(dispatcher.Spans()[0]).Duration())
+}
+
+func (suite *SpanHandleTestSuite) TestFinishFromManyGoroutinesDispatchesOnce() {
+	handle, err := DetachSpan(suite.tracer.StartSpan("job"))
+	suite.Require().NoError(err)
+
+	dispatcher := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle.Finish(dispatcher, SystemClock)
+		}()
+	}
+	wg.Wait()
+	suite.Len(dispatcher.Spans(), 1)
+}
+
+func (suite *SpanHandleTestSuite) TestInvalidHandles() {
+	span := suite.tracer.StartSpan("job")
+	_, err := DetachSpan(span)
+	suite.Require().NoError(err)
+	_, err = DetachSpan(span)
+	suite.Error(err)
+	_, err = DetachSpan(opentracing.NoopTracer{}.StartSpan("job"))
+	suite.Error(err)
+
+	_, err = UnmarshalSpanHandle([]byte("garbage"))
+	suite.Error(err)
+	_, err = UnmarshalSpanHandle(nil)
+	suite.Error(err)
+}
+
+func TestUnitSpanHandleSuite(t *testing.T) {
+	suite.Run(t, new(SpanHandleTestSuite))
+}
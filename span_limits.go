@@ -0,0 +1,116 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"unicode/utf8"
+
+	"github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	// DroppedTagsTagKey is the tag counting the tags dropped because of SpanLimits.MaxTags
+	DroppedTagsTagKey = "haystack.dropped_tags"
+
+	// DroppedLogsTagKey is the tag counting the logs dropped because of SpanLimits.MaxLogs
+	DroppedLogsTagKey = "haystack.dropped_logs"
+
+	// DroppedLogFieldsTagKey is the tag counting the log fields dropped because of SpanLimits.MaxFieldsPerLog
+	DroppedLogFieldsTagKey = "haystack.dropped_log_fields"
+
+	// TruncatedValuesTagKey is the tag counting the string and binary values truncated because of SpanLimits
+	TruncatedValuesTagKey = "haystack.truncated_values"
+
+	// DroppedBaggageTagKey is the tag counting the baggage items dropped because of SpanLimits.MaxBaggageBytes
+	DroppedBaggageTagKey = "haystack.dropped_baggage"
+)
+
+/*SpanLimits bounds the size of the spans, a zero value means no limit. The items beyond the limits are dropped and counted in indicator tags added when the span finishes*/
+type SpanLimits struct {
+	// MaxTags is the number of tags of a span, including the common tags of the tracer and the references tag, which is never dropped nor truncated
+	MaxTags int
+	// MaxLogs is the number of logs of a span
+	MaxLogs int
+	// MaxFieldsPerLog is the number of fields of a log
+	MaxFieldsPerLog int
+	// MaxStringLength is the number of bytes of a tag or log field value sent as a string, errors included, longer values are cut on a rune boundary
+	MaxStringLength int
+	// MaxBinaryLength is the number of bytes of a []byte tag or log field value
+	MaxBinaryLength int
+	// MaxBaggageBytes is the total size of the keys and values of the baggage
+	MaxBaggageBytes int
+}
+
+// spanDrops counts what a span lost to the limits
+type spanDrops struct {
+	tags            int
+	logs            int
+	logFields       int
+	truncatedValues int
+	baggage         int
+}
+
+// limitValue truncates the string and binary values longer than the limits, the values sent as strings like errors
+// are replaced by their truncated string
+func (limits *SpanLimits) limitValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if limits.MaxStringLength > 0 && len(v) > limits.MaxStringLength {
+			return truncateString(v, limits.MaxStringLength), true
+		}
+	case []byte:
+		if limits.MaxBinaryLength > 0 && len(v) > limits.MaxBinaryLength {
+			return v[:limits.MaxBinaryLength], true
+		}
+	default:
+		if limits.MaxStringLength > 0 {
+			if tag := ConvertToProtoTag("", value); tag.GetType() == Tag_STRING && len(tag.GetVStr()) > limits.MaxStringLength {
+				return truncateString(tag.GetVStr(), limits.MaxStringLength), true
+			}
+		}
+	}
+	return value, false
+}
+
+// limitField truncates the string and binary values of the field
+func (limits *SpanLimits) limitField(field log.Field) (log.Field, bool) {
+	value, truncated := limits.limitValue(field.Value())
+	if !truncated {
+		return field, false
+	}
+	if str, ok := value.(string); ok {
+		return log.String(field.Key(), str), true
+	}
+	return log.Object(field.Key(), value), true
+}
+
+// truncateString cuts the string to at most max bytes without splitting a rune
+func truncateString(value string, max int) string {
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
+
+func baggageBytes(baggage map[string]string) int {
+	size := 0
+	for k, v := range baggage {
+		size += len(k) + len(v)
+	}
+	return size
+}
@@ -0,0 +1,148 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpanLimitsTestSuite struct {
+	suite.Suite
+	tracer *Tracer
+}
+
+func (suite *SpanLimitsTestSuite) SetupTest() {
+	tracer, _ := NewTracer("my-service", NewInMemoryDispatcher(),
+		TracerOptionsFactory.Tag("appVer", "v1.1"),
+		TracerOptionsFactory.SpanLimits(SpanLimits{
+			MaxTags:         3,
+			MaxLogs:         2,
+			MaxFieldsPerLog: 2,
+			MaxStringLength: 5,
+			MaxBinaryLength: 2,
+			MaxBaggageBytes: 10,
+		}))
+	suite.tracer = tracer.(*Tracer)
+}
+
+func (suite *SpanLimitsTestSuite) TearDownTest() {
+	suite.NoError(suite.tracer.Close())
+}
+
+func (suite *SpanLimitsTestSuite) tag(span *_Span, key string) interface{} {
+	for _, tag := range span.Tags() {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return nil
+}
+
+func (suite *SpanLimitsTestSuite) TestTagLimits() {
+	span := suite.tracer.StartSpan("op").(*_Span)
+	span.SetTag("payload", "abcdéfg")
+	span.SetTag("raw", []byte{1, 2, 3})
+	span.SetTag("dropped", 1)
+	span.SetTag("dropped-too", 2)
+	// overwriting an existing key is not limited
+	span.SetTag("appVer", "v1.2")
+	span.Finish()
+
+	// é takes the 5th and 6th bytes and is not split
+	suite.Equal("abcd", suite.tag(span, "payload"))
+	suite.Equal([]byte{1, 2}, suite.tag(span, "raw"))
+	suite.Equal("v1.2", suite.tag(span, "appVer"))
+	suite.Nil(suite.tag(span, "dropped"))
+	suite.Equal(2, suite.tag(span, DroppedTagsTagKey))
+	suite.Equal(2, suite.tag(span, TruncatedValuesTagKey))
+	suite.Len(span.Tags(), 5)
+}
+
+func (suite *SpanLimitsTestSuite) TestLogLimits() {
+	span := suite.tracer.StartSpan("op").(*_Span)
+	span.LogFields(log.String("event", "req"), log.String("message", strings.Repeat("x", 10)), log.Int("size", 3))
+	span.LogKV("event", "done")
+	span.LogKV("event", "dropped")
+	span.FinishWithOptions(opentracing.FinishOptions{
+		LogRecords: []opentracing.LogRecord{{Fields: []log.Field{log.String("event", "finish")}}},
+	})
+
+	suite.Require().Len(span.Logs(), 2)
+	suite.Len(span.Logs()[0].Fields, 2)
+	suite.Equal("xxxxx", span.Logs()[0].Fields[1].Value())
+	suite.Equal(2, suite.tag(span, DroppedLogsTagKey))
+	suite.Equal(1, suite.tag(span, DroppedLogFieldsTagKey))
+	suite.Equal(1, suite.tag(span, TruncatedValuesTagKey))
+}
+
+func (suite *SpanLimitsTestSuite) TestValuesSentAsStringsAreTruncated() {
+	span := suite.tracer.StartSpan("op").(*_Span)
+	err := errors.New("connection refused")
+	span.SetTag("error.object", err)
+	span.SetTag("short", errors.New("eof"))
+	span.LogFields(log.Error(err))
+	span.Finish()
+
+	suite.Equal("conne", suite.tag(span, "error.object"))
+	// the values within the limit keep their type
+	suite.Equal(errors.New("eof"), suite.tag(span, "short"))
+	suite.Equal("conne", span.Logs()[0].Fields[0].Value())
+	suite.Equal(2, suite.tag(span, TruncatedValuesTagKey))
+
+	span = suite.tracer.StartSpan("op").(*_Span)
+	span.SetTag("order", struct{ ID string }{ID: "1234567"})
+	span.Finish()
+	suite.Equal("{1234", suite.tag(span, "order"))
+}
+
+func (suite *SpanLimitsTestSuite) TestBaggageLimit() {
+	span := suite.tracer.StartSpan("op").(*_Span)
+	span.SetBaggageItem("user", "bob")
+	span.SetBaggageItem("user", "alice")
+	span.SetBaggageItem("tenant", "acme")
+	span.Finish()
+
+	suite.Equal("alice", span.BaggageItem("user"))
+	suite.Equal("", span.BaggageItem("tenant"))
+	suite.Equal(1, suite.tag(span, DroppedBaggageTagKey))
+}
+
+func (suite *SpanLimitsTestSuite) TestDropsAreCounted() {
+	for i := 0; i < 2; i++ {
+		span := suite.tracer.StartSpan("op")
+		span.SetTag("a", 1).SetTag("b", 2).SetTag("c", 3)
+		span.Finish()
+	}
+	unlimited := suite.tracer.StartSpan("op").(*_Span)
+	unlimited.Finish()
+	suite.Nil(suite.tag(unlimited, DroppedTagsTagKey))
+
+	stats := suite.tracer.Stats()
+	suite.Equal(int64(2), stats.TagsDropped)
+	suite.Equal(int64(0), stats.LogsDropped)
+}
+
+func TestUnitSpanLimitsSuite(t *testing.T) {
+	suite.Run(t, new(SpanLimitsTestSuite))
+}
@@ -0,0 +1,189 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+/*SpanRecord is a read only, opentracing friendly model of a finished span, e.g. one read back from kafka or a span file. Tag and log field values keep the type of the proto tag: string, float64, bool, int64 or []byte*/
+type SpanRecord struct {
+	context       SpanContext
+	serviceName   string
+	operationName string
+	startTime     time.Time
+	duration      time.Duration
+	tags          []opentracing.Tag
+	logs          []opentracing.LogRecord
+}
+
+/*NewSpanRecord converts the proto span into a span record*/
+func NewSpanRecord(span *Span) *SpanRecord {
+	record := &SpanRecord{
+		context: SpanContext{
+			TraceID:  span.GetTraceId(),
+			SpanID:   span.GetSpanId(),
+			ParentID: span.GetParentSpanId(),
+		},
+		serviceName:   span.GetServiceName(),
+		operationName: span.GetOperationName(),
+		startTime:     fromMicros(span.GetStartTime()),
+		duration:      time.Duration(span.GetDuration()) * time.Microsecond,
+	}
+	for _, tag := range span.GetTags() {
+		record.tags = append(record.tags, ConvertFromProtoTag(tag))
+	}
+	for _, lg := range span.GetLogs() {
+		record.logs = append(record.logs, ConvertFromProtoLog(lg))
+	}
+	return record
+}
+
+/*Context returns the span context, proto spans do not carry baggage*/
+func (r *SpanRecord) Context() opentracing.SpanContext {
+	return &r.context
+}
+
+/*ServiceName returns the name of the service*/
+func (r *SpanRecord) ServiceName() string {
+	return r.serviceName
+}
+
+/*OperationName returns the operation name*/
+func (r *SpanRecord) OperationName() string {
+	return r.operationName
+}
+
+/*StartTime returns the start time of the span with microseconds precision*/
+func (r *SpanRecord) StartTime() time.Time {
+	return r.startTime
+}
+
+/*Duration returns the duration of the span with microseconds precision*/
+func (r *SpanRecord) Duration() time.Duration {
+	return r.duration
+}
+
+/*Tags returns the tags of the span*/
+func (r *SpanRecord) Tags() []opentracing.Tag {
+	return r.tags
+}
+
+/*Tag returns the value of the last tag with the key*/
+func (r *SpanRecord) Tag(key string) (interface{}, bool) {
+	for i := len(r.tags) - 1; i >= 0; i-- {
+		if r.tags[i].Key == key {
+			return r.tags[i].Value, true
+		}
+	}
+	return nil, false
+}
+
+/*Logs returns the logs of the span*/
+func (r *SpanRecord) Logs() []opentracing.LogRecord {
+	return r.logs
+}
+
+/*ToProto converts the span record back into a proto span*/
+func (r *SpanRecord) ToProto() *Span {
+	return &Span{
+		TraceId:       r.context.TraceID,
+		SpanId:        r.context.SpanID,
+		ParentSpanId:  r.context.ParentID,
+		ServiceName:   r.serviceName,
+		OperationName: r.operationName,
+		StartTime:     toMicros(r.startTime),
+		Duration:      r.duration.Nanoseconds() / int64(time.Microsecond),
+		Tags:          protoTags(r.tags),
+		Logs:          protoLogs(r.logs),
+	}
+}
+
+/*ConvertFromProtoTag converts the proto tag into an opentracing tag with a typed value*/
+func ConvertFromProtoTag(tag *Tag) opentracing.Tag {
+	return opentracing.Tag{
+		Key:   tag.GetKey(),
+		Value: protoTagValue(tag),
+	}
+}
+
+/*ConvertFromProtoLog converts the proto log into an opentracing log record with typed fields*/
+func ConvertFromProtoLog(lg *Log) opentracing.LogRecord {
+	record := opentracing.LogRecord{
+		Timestamp: fromMicros(lg.GetTimestamp()),
+	}
+	for _, field := range lg.GetFields() {
+		record.Fields = append(record.Fields, protoTagToLogField(field))
+	}
+	return record
+}
+
+func protoTagValue(tag *Tag) interface{} {
+	switch v := tag.GetMyvalue().(type) {
+	case *Tag_VStr:
+		return v.VStr
+	case *Tag_VLong:
+		return v.VLong
+	case *Tag_VDouble:
+		return v.VDouble
+	case *Tag_VBool:
+		return v.VBool
+	case *Tag_VBytes:
+		return v.VBytes
+	}
+
+	// no value was set, fall back to the zero value of the declared type
+	switch tag.GetType() {
+	case Tag_LONG:
+		return int64(0)
+	case Tag_DOUBLE:
+		return float64(0)
+	case Tag_BOOL:
+		return false
+	case Tag_BINARY:
+		return []byte{}
+	default:
+		return ""
+	}
+}
+
+func protoTagToLogField(tag *Tag) log.Field {
+	switch v := protoTagValue(tag).(type) {
+	case int64:
+		return log.Int64(tag.GetKey(), v)
+	case float64:
+		return log.Float64(tag.GetKey(), v)
+	case bool:
+		return log.Bool(tag.GetKey(), v)
+	case string:
+		return log.String(tag.GetKey(), v)
+	default:
+		return log.Object(tag.GetKey(), v)
+	}
+}
+
+func fromMicros(micros int64) time.Time {
+	return time.Unix(0, micros*int64(time.Microsecond))
+}
+
+func toMicros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
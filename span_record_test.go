@@ -0,0 +1,119 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpanRecordTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SpanRecordTestSuite) TestProtoRoundTrip() {
+	protoSpan := &Span{
+		TraceId:       "T1",
+		SpanId:        "S1",
+		ParentSpanId:  "P1",
+		ServiceName:   "my-service",
+		OperationName: "op1",
+		StartTime:     1552380000123456,
+		Duration:      1500,
+		Tags: []*Tag{
+			ConvertToProtoTag("str", "v1"),
+			ConvertToProtoTag("long", int64(-42)),
+			ConvertToProtoTag("double", 1.5),
+			ConvertToProtoTag("bool", true),
+			ConvertToProtoTag("bytes", []byte{0, 1, 2}),
+		},
+		Logs: []*Log{
+			{
+				Timestamp: 1552380000124000,
+				Fields: []*Tag{
+					ConvertToProtoTag("event", "cache-miss"),
+					ConvertToProtoTag("count", int64(3)),
+					ConvertToProtoTag("payload", []byte("raw")),
+				},
+			},
+		},
+	}
+
+	record := NewSpanRecord(protoSpan)
+	suite.Equal("op1", record.OperationName())
+	suite.Equal("my-service", record.ServiceName())
+	suite.Equal(int64(1552380000123456000), record.StartTime().UnixNano())
+	suite.Equal(1500*time.Microsecond, record.Duration())
+	suite.Equal("P1", record.Context().(*SpanContext).ParentID)
+
+	value, ok := record.Tag("long")
+	suite.True(ok)
+	suite.Equal(int64(-42), value)
+	value, _ = record.Tag("bytes")
+	suite.Equal([]byte{0, 1, 2}, value)
+	_, ok = record.Tag("missing")
+	suite.False(ok)
+
+	suite.Require().Len(record.Logs(), 1)
+	suite.Equal("count:3", record.Logs()[0].Fields[1].String())
+
+	suite.True(proto.Equal(protoSpan, record.ToProto()), "proto span should survive the round trip, got %v", record.ToProto())
+}
+
+func (suite *SpanRecordTestSuite) TestRecordsFromTracerSpans() {
+	dispatcher := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	tracer, closer := NewTracer("my-service", dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	span := tracer.StartSpan("op1")
+	span.SetTag("http.status_code", 200)
+	span.Finish()
+
+	spans := dispatcher.Spans()
+	suite.Require().Len(spans, 1)
+	value, ok := NewSpanRecord(spans[0]).Tag("http.status_code")
+	suite.True(ok)
+	suite.Equal(int64(200), value, "int tags are read back as int64")
+}
+
+func (suite *SpanRecordTestSuite) TestUnsupportedValuesAreStringified() {
+	dispatcher := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	tracer, closer := NewTracer("my-service", dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	span := tracer.StartSpan("op1")
+	span.SetTag("order", struct{ ID int }{ID: 7})
+	span.LogKV("at", time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC))
+	suite.NotPanics(span.Finish)
+
+	record := NewSpanRecord(dispatcher.Spans()[0])
+	value, _ := record.Tag("order")
+	suite.Equal("{7}", value)
+	suite.Equal("2018-07-01 10:00:00 +0000 UTC", record.Logs()[0].Fields[0].Value())
+}
+
+func TestUnitSpanRecordSuite(t *testing.T) {
+	suite.Run(t, new(SpanRecordTestSuite))
+}
@@ -0,0 +1,96 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"encoding/json"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+/*ReferencesTagKey is the tag listing the references of a span that has several ones or a FollowsFrom one, as a json array of {"type": "child_of" or "follows_from", "traceId": ..., "spanId": ...} objects in the order of the StartSpan options*/
+const ReferencesTagKey = "haystack.references"
+
+/*ReferencePriority tells which reference becomes the parent of a span started with several ones*/
+type ReferencePriority int
+
+const (
+	// PreferChildOf picks the first ChildOf reference, or the first FollowsFrom one if there is none
+	PreferChildOf ReferencePriority = iota
+	// PreferFollowsFrom picks the first FollowsFrom reference, or the first ChildOf one if there is none
+	PreferFollowsFrom
+	// FirstReference picks the first reference whatever its type
+	FirstReference
+)
+
+type referenceJSON struct {
+	Type    string `json:"type"`
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+// haystackReferences keeps the references to haystack span contexts, the others cannot be recorded
+func haystackReferences(references []opentracing.SpanReference) []opentracing.SpanReference {
+	var kept []opentracing.SpanReference
+	for _, ref := range references {
+		if _, ok := ref.ReferencedContext.(*SpanContext); ok {
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
+
+// parentOf picks the parent among the references according to the priority, nil if there is no reference
+func (priority ReferencePriority) parentOf(references []opentracing.SpanReference) *SpanContext {
+	if len(references) == 0 {
+		return nil
+	}
+	preferred := opentracing.ChildOfRef
+	switch priority {
+	case FirstReference:
+		return references[0].ReferencedContext.(*SpanContext)
+	case PreferFollowsFrom:
+		preferred = opentracing.FollowsFromRef
+	}
+	for _, ref := range references {
+		if ref.Type == preferred {
+			return ref.ReferencedContext.(*SpanContext)
+		}
+	}
+	return references[0].ReferencedContext.(*SpanContext)
+}
+
+// encodeReferences returns the value of the references tag, empty if a single ChildOf reference says it all
+func encodeReferences(references []opentracing.SpanReference) string {
+	if len(references) == 0 || len(references) == 1 && references[0].Type == opentracing.ChildOfRef {
+		return ""
+	}
+	refs := make([]referenceJSON, len(references))
+	for i, ref := range references {
+		spanContext := ref.ReferencedContext.(*SpanContext)
+		refs[i] = referenceJSON{Type: "child_of", TraceID: spanContext.TraceID, SpanID: spanContext.SpanID}
+		if ref.Type == opentracing.FollowsFromRef {
+			refs[i].Type = "follows_from"
+		}
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
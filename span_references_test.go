@@ -0,0 +1,137 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpanReferencesTestSuite struct {
+	suite.Suite
+}
+
+func (suite *SpanReferencesTestSuite) startSpan(tracer opentracing.Tracer, options ...opentracing.StartSpanOption) *_Span {
+	return tracer.StartSpan("consume", options...).(*_Span)
+}
+
+func (suite *SpanReferencesTestSuite) referencesTag(span *_Span) []map[string]string {
+	for _, tag := range span.Tags() {
+		if tag.Key == ReferencesTagKey {
+			var refs []map[string]string
+			suite.Require().NoError(json.Unmarshal([]byte(tag.Value.(string)), &refs))
+			return refs
+		}
+	}
+	return nil
+}
+
+func (suite *SpanReferencesTestSuite) TestAllReferencesAreKept() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(), TracerOptionsFactory.UseDualSpanMode())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	producer1 := tracer.StartSpan("produce").Context().(*SpanContext)
+	producer2 := tracer.StartSpan("produce").Context().(*SpanContext)
+	poll := tracer.StartSpan("poll").Context().(*SpanContext)
+
+	span := suite.startSpan(tracer, opentracing.FollowsFrom(producer1), opentracing.ChildOf(poll), opentracing.FollowsFrom(producer2))
+	suite.Equal(poll.SpanID, span.context.ParentID)
+	suite.Len(span.References(), 3)
+	suite.Equal([]map[string]string{
+		{"type": "follows_from", "traceId": producer1.TraceID, "spanId": producer1.SpanID},
+		{"type": "child_of", "traceId": poll.TraceID, "spanId": poll.SpanID},
+		{"type": "follows_from", "traceId": producer2.TraceID, "spanId": producer2.SpanID},
+	}, suite.referencesTag(span))
+
+	child := suite.startSpan(tracer, opentracing.ChildOf(poll))
+	suite.Nil(suite.referencesTag(child), "a single ChildOf reference is the parent id")
+	root := suite.startSpan(tracer)
+	suite.Nil(suite.referencesTag(root))
+
+	follower := suite.startSpan(tracer, opentracing.FollowsFrom(producer1))
+	suite.Equal(producer1.SpanID, follower.context.ParentID)
+	suite.Len(suite.referencesTag(follower), 1)
+}
+
+func (suite *SpanReferencesTestSuite) TestReferencesAreExemptFromTheSpanLimits() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(),
+		TracerOptionsFactory.Tag("appVer", "v1.1"),
+		TracerOptionsFactory.SpanLimits(SpanLimits{MaxTags: 1, MaxStringLength: 10}))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	producer1 := tracer.StartSpan("produce").Context().(*SpanContext)
+	producer2 := tracer.StartSpan("produce").Context().(*SpanContext)
+
+	span := suite.startSpan(tracer, opentracing.FollowsFrom(producer1), opentracing.FollowsFrom(producer2))
+	suite.Equal([]map[string]string{
+		{"type": "follows_from", "traceId": producer1.TraceID, "spanId": producer1.SpanID},
+		{"type": "follows_from", "traceId": producer2.TraceID, "spanId": producer2.SpanID},
+	}, suite.referencesTag(span), "the references should be complete and parse")
+	suite.Equal(0, span.drops.tags)
+	suite.Equal(0, span.drops.truncatedValues)
+}
+
+func (suite *SpanReferencesTestSuite) TestReferencePriority() {
+	a := &SpanContext{TraceID: "t1", SpanID: "a"}
+	b := &SpanContext{TraceID: "t1", SpanID: "b"}
+	references := []opentracing.SpanReference{
+		{Type: opentracing.FollowsFromRef, ReferencedContext: a},
+		{Type: opentracing.ChildOfRef, ReferencedContext: b},
+	}
+
+	suite.Equal(b, PreferChildOf.parentOf(references))
+	suite.Equal(a, PreferFollowsFrom.parentOf(references))
+	suite.Equal(a, FirstReference.parentOf(references))
+	suite.Equal(b, PreferFollowsFrom.parentOf(references[1:]))
+	suite.Nil(PreferChildOf.parentOf(nil))
+
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(),
+		TracerOptionsFactory.UseDualSpanMode(), TracerOptionsFactory.ReferencePriority(PreferFollowsFrom))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	span := suite.startSpan(tracer, opentracing.ChildOf(b), opentracing.FollowsFrom(a))
+	suite.Equal("a", span.context.ParentID)
+}
+
+func (suite *SpanReferencesTestSuite) TestReferencesReachTheProtoSpan() {
+	dispatcher := NewInMemoryDispatcher().(*InMemoryDispatcher)
+	tracer, closer := NewTracer("my-service", dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	producer := tracer.StartSpan("produce").Context()
+	span := tracer.StartSpan("consume", opentracing.FollowsFrom(producer), opentracing.FollowsFrom(producer))
+	span.Finish()
+
+	value, ok := NewSpanRecord(dispatcher.SpansByOperation("consume")[0]).Tag(ReferencesTagKey)
+	suite.True(ok)
+	suite.Contains(value, `"type":"follows_from"`)
+}
+
+func TestUnitSpanReferencesSuite(t *testing.T) {
+	suite.Run(t, new(SpanReferencesTestSuite))
+}
@@ -20,8 +20,10 @@ package haystack
 import (
 	"io"
 	"testing"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -57,6 +59,32 @@ func (suite *SpanTestSuite) TestSpanProperties() {
 	suite.Equal(suite.tracer, sp1.Tracer())
 	suite.NotNil(sp1.Context())
 }
+
+func (suite *SpanTestSuite) TestSetTagOverwritesInPlace() {
+	span := suite.tracer.StartSpan("op", opentracing.Tag{Key: "t1", Value: "v2"}).(*_Span)
+	span.SetTag("error", false)
+	span.SetTag("http.status_code", 500)
+	span.SetTag("error", true)
+
+	suite.Equal([]opentracing.Tag{
+		{Key: "t1", Value: "v2"},
+		{Key: "error", Value: true},
+		{Key: "http.status_code", Value: 500},
+	}, span.Tags())
+}
+
+func (suite *SpanTestSuite) TestMultiValueTags() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(),
+		TracerOptionsFactory.Tag("t1", "v1"), TracerOptionsFactory.MultiValueTags())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	span := tracer.StartSpan("op").(*_Span)
+	span.SetTag("t1", "v2")
+	suite.Equal([]opentracing.Tag{{Key: "t1", Value: "v1"}, {Key: "t1", Value: "v2"}}, span.Tags())
+}
+
 func (suite *SpanTestSuite) TestBaggageIterator() {
 	span1 := suite.tracer.StartSpan("sp1").(*_Span)
 
@@ -70,6 +98,66 @@ func (suite *SpanTestSuite) TestBaggageIterator() {
 	suite.Equal(expectedBaggage, extractBaggage(span2))
 }
 
+func (suite *SpanTestSuite) TestSetBaggageItemKeepsContextFields() {
+	extracted := &SpanContext{TraceID: "t1", SpanID: "s1", ParentID: "p1", IsExtractedContext: true}
+	withBaggage := extracted.WithBaggageItem("user", "alice")
+	suite.Equal(&SpanContext{
+		TraceID:            "t1",
+		SpanID:             "s1",
+		ParentID:           "p1",
+		Baggage:            map[string]string{"user": "alice"},
+		IsExtractedContext: true,
+	}, withBaggage)
+	suite.Nil(extracted.Baggage)
+}
+
+func (suite *SpanTestSuite) TestDisableBaggageLogging() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(), TracerOptionsFactory.DisableBaggageLogging())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	span := tracer.StartSpan("op").(*_Span)
+	span.SetBaggageItem("user", "alice")
+	suite.Equal("alice", span.BaggageItem("user"))
+	suite.Empty(span.Logs())
+}
+
+func (suite *SpanTestSuite) TestTimesComeFromTracerClock() {
+	now := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	clock := ClockFunc(func() time.Time { return now })
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher(), TracerOptionsFactory.Clock(clock))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	span := tracer.StartSpan("op").(*_Span)
+	now = now.Add(time.Second)
+	span.LogFields(log.String("event", "fields"))
+	now = now.Add(time.Second)
+	span.LogEvent("event")
+	now = now.Add(time.Second)
+	span.FinishWithOptions(opentracing.FinishOptions{
+		LogRecords: []opentracing.LogRecord{{Fields: []log.Field{log.String("event", "finish")}}},
+	})
+
+	start := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	suite.Equal(start, span.StartTime())
+	suite.Equal(3*time.Second, span.Duration())
+	suite.Require().Len(span.Logs(), 3)
+	suite.Equal(start.Add(time.Second), span.Logs()[0].Timestamp)
+	suite.Equal(start.Add(2*time.Second), span.Logs()[1].Timestamp)
+	suite.Equal(start.Add(3*time.Second), span.Logs()[2].Timestamp)
+}
+
+func (suite *SpanTestSuite) TestSystemClockDurationIsMonotonic() {
+	span := suite.tracer.StartSpan("op").(*_Span)
+	span.Finish()
+	// the m= part of the formatted time is its monotonic clock reading
+	suite.Contains(span.StartTime().String(), "m=")
+	suite.True(span.Duration() >= 0)
+}
+
 func extractBaggage(sp opentracing.Span) map[string]string {
 	b := make(map[string]string)
 	sp.Context().ForeachBaggageItem(func(k, v string) bool {
@@ -84,6 +172,8 @@ func assertBaggageRecords(suite *SpanTestSuite, sp *_Span, expected map[string]s
 	for _, logRecord := range sp.logs {
 		suite.Len(logRecord.Fields, 3)
 		suite.Equal("event:baggage", logRecord.Fields[0].String())
+		suite.Equal("key", logRecord.Fields[1].Key())
+		suite.Equal("value", logRecord.Fields[2].Key())
 		key := logRecord.Fields[1].Value().(string)
 		value := logRecord.Fields[2].Value().(string)
 
@@ -0,0 +1,144 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+/*Stats is a snapshot of the self metrics of the tracer and its dispatcher, the counters are totals since the tracer was created*/
+type Stats struct {
+	// SpansStarted counts the spans started by the tracer
+	SpansStarted int64 `json:"spansStarted"`
+	// SpansFinished counts the spans finished and handed to the dispatcher
+	SpansFinished int64 `json:"spansFinished"`
+	// SpansDetached counts the spans detached to a SpanHandle, which may be finished by another process
+	SpansDetached int64 `json:"spansDetached"`
+	// SpansEnqueued counts the spans queued by the dispatcher for sending
+	SpansEnqueued int64 `json:"spansEnqueued"`
+	// SpansSent counts the spans successfully sent by the dispatcher
+	SpansSent int64 `json:"spansSent"`
+	// SpansFailed counts the spans the dispatcher failed to send
+	SpansFailed int64 `json:"spansFailed"`
+	// SpansDropped counts the spans dropped because the dispatcher queue was full
+	SpansDropped int64 `json:"spansDropped"`
+	// QueueDepth is the number of spans waiting in the dispatcher queue
+	QueueDepth int `json:"queueDepth"`
+	// QueueCapacity is the maximum number of spans the dispatcher queue can hold
+	QueueCapacity int `json:"queueCapacity"`
+	// SendLatencySum is the total time spent sending spans, successfully or not
+	SendLatencySum time.Duration `json:"sendLatencySumNanos"`
+	// SendLatencyMax is the longest time spent sending a span
+	SendLatencyMax time.Duration `json:"sendLatencyMaxNanos"`
+	// TagsDropped counts the tags dropped because of SpanLimits.MaxTags
+	TagsDropped int64 `json:"tagsDropped"`
+	// LogsDropped counts the logs dropped because of SpanLimits.MaxLogs
+	LogsDropped int64 `json:"logsDropped"`
+	// LogFieldsDropped counts the log fields dropped because of SpanLimits.MaxFieldsPerLog
+	LogFieldsDropped int64 `json:"logFieldsDropped"`
+	// ValuesTruncated counts the string and binary values truncated because of SpanLimits
+	ValuesTruncated int64 `json:"valuesTruncated"`
+	// BaggageItemsDropped counts the baggage items dropped because of SpanLimits.MaxBaggageBytes
+	BaggageItemsDropped int64 `json:"baggageItemsDropped"`
+}
+
+/*StatsReporter is implemented by the dispatchers that report their self metrics, the tracer merges them in its own Stats*/
+type StatsReporter interface {
+	Stats() Stats
+}
+
+/*Stats returns the self metrics of the tracer, including the ones of its dispatcher if it is a StatsReporter*/
+func (tracer *Tracer) Stats() Stats {
+	var stats Stats
+	if reporter, ok := tracer.dispatcher.(StatsReporter); ok {
+		stats = reporter.Stats()
+	}
+	stats.SpansStarted = atomic.LoadInt64(&tracer.spansStarted)
+	stats.SpansFinished = atomic.LoadInt64(&tracer.spansFinished)
+	stats.SpansDetached = atomic.LoadInt64(&tracer.spansDetached)
+	stats.TagsDropped = atomic.LoadInt64(&tracer.drops.tags)
+	stats.LogsDropped = atomic.LoadInt64(&tracer.drops.logs)
+	stats.LogFieldsDropped = atomic.LoadInt64(&tracer.drops.logFields)
+	stats.ValuesTruncated = atomic.LoadInt64(&tracer.drops.truncatedValues)
+	stats.BaggageItemsDropped = atomic.LoadInt64(&tracer.drops.baggage)
+	return stats
+}
+
+// dropCounters totals the items the spans lost to the span limits
+type dropCounters struct {
+	tags            int64
+	logs            int64
+	logFields       int64
+	truncatedValues int64
+	baggage         int64
+}
+
+func (tracer *Tracer) recordDrops(drops spanDrops) {
+	atomic.AddInt64(&tracer.drops.tags, int64(drops.tags))
+	atomic.AddInt64(&tracer.drops.logs, int64(drops.logs))
+	atomic.AddInt64(&tracer.drops.logFields, int64(drops.logFields))
+	atomic.AddInt64(&tracer.drops.truncatedValues, int64(drops.truncatedValues))
+	atomic.AddInt64(&tracer.drops.baggage, int64(drops.baggage))
+}
+
+/*PublishExpvar exposes the tracer Stats as an expvar variable with the given name, expvar panics if the name is already published*/
+func (tracer *Tracer) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return tracer.Stats()
+	}))
+}
+
+// dispatcherStats counts the spans going through a dispatcher queue
+type dispatcherStats struct {
+	enqueued        int64
+	sent            int64
+	failed          int64
+	dropped         int64
+	latencyNanos    int64
+	maxLatencyNanos int64
+}
+
+func (s *dispatcherStats) recordSend(latency time.Duration, err error) {
+	if err != nil {
+		atomic.AddInt64(&s.failed, 1)
+	} else {
+		atomic.AddInt64(&s.sent, 1)
+	}
+	atomic.AddInt64(&s.latencyNanos, int64(latency))
+	for {
+		max := atomic.LoadInt64(&s.maxLatencyNanos)
+		if int64(latency) <= max || atomic.CompareAndSwapInt64(&s.maxLatencyNanos, max, int64(latency)) {
+			return
+		}
+	}
+}
+
+func (s *dispatcherStats) snapshot(queue chan *Span) Stats {
+	return Stats{
+		SpansEnqueued:  atomic.LoadInt64(&s.enqueued),
+		SpansSent:      atomic.LoadInt64(&s.sent),
+		SpansFailed:    atomic.LoadInt64(&s.failed),
+		SpansDropped:   atomic.LoadInt64(&s.dropped),
+		QueueDepth:     len(queue),
+		QueueCapacity:  cap(queue),
+		SendLatencySum: time.Duration(atomic.LoadInt64(&s.latencyNanos)),
+		SendLatencyMax: time.Duration(atomic.LoadInt64(&s.maxLatencyNanos)),
+	}
+}
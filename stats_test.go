@@ -0,0 +1,162 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeRemoteClient fails the spans of the "fail" operation
+type fakeRemoteClient struct{}
+
+func (c fakeRemoteClient) Send(span *Span) {}
+func (c fakeRemoteClient) TrySend(span *Span) error {
+	if span.GetOperationName() == "fail" {
+		return errors.New("agent is unavailable")
+	}
+	return nil
+}
+func (c fakeRemoteClient) Close() error            { return nil }
+func (c fakeRemoteClient) SetLogger(logger Logger) {}
+
+type StatsTestSuite struct {
+	suite.Suite
+}
+
+func (suite *StatsTestSuite) TestRemoteDispatcherStats() {
+	var logs bytes.Buffer
+	dispatcher := &RemoteDispatcher{
+		client:       fakeRemoteClient{},
+		spanChannel:  make(chan *Span, 2),
+		dropWhenFull: true,
+	}
+	tracer, closer := NewTracer("my-service", dispatcher, TracerOptionsFactory.Logger(NewStdLogger(log.New(&logs, "", 0))))
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+
+	tracer.StartSpan("fail").Finish()
+	tracer.StartSpan("ok").Finish()
+	tracer.StartSpan("dropped").Finish()
+	tracer.StartSpan("dropped-too").Finish()
+	tracer.StartSpan("unfinished")
+
+	stats := tracer.(*Tracer).Stats()
+	suite.Equal(int64(5), stats.SpansStarted)
+	suite.Equal(int64(4), stats.SpansFinished)
+	suite.Equal(int64(2), stats.SpansEnqueued)
+	suite.Equal(int64(2), stats.SpansDropped)
+	suite.Equal(1, strings.Count(logs.String(), "ERROR span queue of 2 is full"), "only the first drop is an error, got %s", logs.String())
+	suite.Equal(2, stats.QueueDepth)
+	suite.Equal(2, stats.QueueCapacity)
+
+	go startListener(dispatcher)
+	suite.Eventually(func() bool {
+		stats = tracer.(*Tracer).Stats()
+		return stats.SpansSent+stats.SpansFailed == 2
+	}, time.Second, time.Millisecond)
+	suite.Equal(int64(1), stats.SpansSent)
+	suite.Equal(int64(1), stats.SpansFailed)
+	suite.Equal(0, stats.QueueDepth)
+	suite.True(stats.SendLatencySum >= stats.SendLatencyMax)
+}
+
+func (suite *StatsTestSuite) TestSendsOfOtherClientsAreCountedAsSent() {
+	dispatcher := &RemoteDispatcher{
+		// the embedding hides TrySend
+		client:      struct{ RemoteClient }{fakeRemoteClient{}},
+		spanChannel: make(chan *Span, 1),
+	}
+	go startListener(dispatcher)
+	dispatcher.DispatchProtoSpan(&Span{OperationName: "fail"})
+	suite.Eventually(func() bool {
+		return dispatcher.Stats().SpansSent == 1
+	}, time.Second, time.Millisecond)
+	suite.Equal(int64(0), dispatcher.Stats().SpansFailed)
+}
+
+func (suite *StatsTestSuite) TestDropWhenQueueFullIsADispatcherOption() {
+	dispatcher := NewHTTPDispatcher("http://localhost/span", time.Second, nil, 1,
+		DispatcherOptionsFactory.DropWhenQueueFull()).(*RemoteDispatcher)
+	defer dispatcher.Close()
+	suite.True(dispatcher.dropWhenFull)
+}
+
+func (suite *StatsTestSuite) TestRemoteDispatcherBlocksWhileTheQueueIsFull() {
+	dispatcher := &RemoteDispatcher{
+		client:      fakeRemoteClient{},
+		spanChannel: make(chan *Span, 1),
+	}
+	tracer, closer := NewTracer("my-service", dispatcher)
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	tracer.StartSpan("queued").Finish()
+
+	finished := make(chan struct{})
+	go func() {
+		tracer.StartSpan("blocked").Finish()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		suite.Fail("the span should wait for room in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go startListener(dispatcher)
+	suite.Eventually(func() bool {
+		select {
+		case <-finished:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	stats := tracer.(*Tracer).Stats()
+	suite.Equal(int64(2), stats.SpansEnqueued)
+	suite.Equal(int64(0), stats.SpansDropped)
+}
+
+func (suite *StatsTestSuite) TestPublishExpvar() {
+	tracer, closer := NewTracer("my-service", NewInMemoryDispatcher())
+	defer func() {
+		suite.NoError(closer.Close())
+	}()
+	tracer.StartSpan("op").Finish()
+
+	tracer.(*Tracer).PublishExpvar("haystack-stats-test")
+
+	var stats map[string]interface{}
+	suite.NoError(json.Unmarshal([]byte(expvar.Get("haystack-stats-test").String()), &stats))
+	suite.Equal(float64(1), stats["spansStarted"])
+	suite.Equal(float64(1), stats["spansFinished"])
+}
+
+func TestUnitStatsSuite(t *testing.T) {
+	suite.Run(t, new(StatsTestSuite))
+}
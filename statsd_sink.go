@@ -0,0 +1,88 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+/*StatsdSink is a MetricsSink sending a statsd timer per span over udp, named <prefix>.<service>.<operation>.<kind>.<success|error>*/
+type StatsdSink struct {
+	conn   net.Conn
+	prefix string
+	logger Logger
+}
+
+/*NewStatsdSink creates a sink sending to the statsd agent at the address, "127.0.0.1:8125" if it is empty*/
+func NewStatsdSink(address string, prefix string) (*StatsdSink, error) {
+	if address == "" {
+		address = "127.0.0.1:8125"
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsdSink{conn: conn, prefix: prefix, logger: NullLogger{}}, nil
+}
+
+/*SetLogger sets the logger to use*/
+func (s *StatsdSink) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+/*Observe implements MetricsSink*/
+func (s *StatsdSink) Observe(key REDKey, duration time.Duration) {
+	outcome := "success"
+	if key.Error {
+		outcome = "error"
+	}
+	kind := key.SpanKind
+	if kind == "" {
+		kind = "internal"
+	}
+	name := strings.Join([]string{
+		statsdName(key.ServiceName), statsdName(key.OperationName), statsdName(kind), outcome,
+	}, ".")
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+
+	line := fmt.Sprintf("%s:%.3f|ms", name, float64(duration)/float64(time.Millisecond))
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		s.logger.Debug("Fail to send the metric %s to statsd, error=%v", name, err)
+	}
+}
+
+/*Close closes the udp connection*/
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
+
+// statsdName replaces the characters having a meaning in the statsd protocol or in graphite paths
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '#', ' ', '/':
+			return '_'
+		}
+		return r
+	}, name)
+}
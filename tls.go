@@ -0,0 +1,256 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+/*TLSOptions defines the tls settings of the connections to the haystack agent or collector, the zero value verifies the server with the system roots*/
+type TLSOptions struct {
+	// CAFile is the pem bundle of the certificate authorities trusted to sign the server certificate, the system roots if empty
+	CAFile string `json:"caFile" yaml:"caFile"`
+	// CertFile is the pem client certificate presented for mutual tls, with KeyFile
+	CertFile string `json:"certFile" yaml:"certFile"`
+	// KeyFile is the pem private key of the client certificate
+	KeyFile string `json:"keyFile" yaml:"keyFile"`
+	// ServerName overrides the name the server certificate is verified against, the host name of the endpoint if empty
+	ServerName string `json:"serverName" yaml:"serverName"`
+	// Reload reads the files again on the next handshake once they have been modified, e.g. by a certificate rotation.
+	// A failed reload keeps the previous certificates and is logged by the client once per modification of the files
+	Reload bool `json:"reload" yaml:"reload"`
+}
+
+/*NewTLSConfig loads the files of the options into a tls config for ClientOptionsFactory.TLSConfig*/
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("tls client certificate and key files must be set together")
+	}
+	files := &tlsFiles{opts: opts, logger: NullLogger{}}
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if !opts.Reload {
+		config.RootCAs = files.roots
+		if files.cert != nil {
+			config.Certificates = []tls.Certificate{*files.cert}
+		}
+		return config, nil
+	}
+
+	if opts.CertFile != "" {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := files.current()
+			return cert, nil
+		}
+	}
+	if opts.CAFile != "" {
+		// the default verification cannot use reloaded roots, VerifyConnection does it instead
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			_, roots := files.current()
+			return verifyServer(state, roots, opts.ServerName)
+		}
+	}
+	reloadingTLSFiles.Store(config, files)
+	return config, nil
+}
+
+// reloadingTLSFiles maps the reloading configs made by NewTLSConfig to their files, so that the clients using a config
+// can set the logger of its reload errors
+var reloadingTLSFiles sync.Map
+
+// setTLSLogger sets the logger of the reload errors of the config if it reloads its files
+func setTLSLogger(config *tls.Config, logger Logger) {
+	if config == nil {
+		return
+	}
+	if files, ok := reloadingTLSFiles.Load(config); ok {
+		files.(*tlsFiles).setLogger(logger)
+	}
+}
+
+// verifyServer does the verification of the server certificate chain and name that tls.Config does by default, the
+// name is the server name of the options, or else the host set by withDialHost
+func verifyServer(state tls.ConnectionState, roots *x509.CertPool, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("tls: server did not present a certificate")
+	}
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+	if serverName == "" {
+		return errors.New("tls: no server name to verify the server certificate against")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// withDialHost returns a copy of the config whose VerifyConnection gets the host as the server name if the handshake
+// has none, as an ip address is not sent as the tls server name
+func withDialHost(config *tls.Config, host string) *tls.Config {
+	if config.ServerName != "" || config.VerifyConnection == nil {
+		return config
+	}
+	verify := config.VerifyConnection
+	config = config.Clone()
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if state.ServerName == "" {
+			state.ServerName = host
+		}
+		return verify(state)
+	}
+	return config
+}
+
+// tlsFiles holds the certificates read from the files of the options
+type tlsFiles struct {
+	opts TLSOptions
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes map[string]time.Time
+	logger   Logger
+	// failedModTimes are the modification times of the files the last reload error was logged for
+	failedModTimes map[string]time.Time
+}
+
+func (f *tlsFiles) setLogger(logger Logger) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logger = logger
+}
+
+// statModTimes returns the modification times of the files, the ones that could be read if it fails
+func (f *tlsFiles) statModTimes() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, name := range []string{f.opts.CAFile, f.opts.CertFile, f.opts.KeyFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[name] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (f *tlsFiles) load() error {
+	modTimes, err := f.statModTimes()
+	if err != nil {
+		return err
+	}
+
+	var roots *x509.CertPool
+	if f.opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(f.opts.CAFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in tls ca file %s", f.opts.CAFile)
+		}
+	}
+	var cert *tls.Certificate
+	if f.opts.CertFile != "" {
+		keyPair, err := tls.LoadX509KeyPair(f.opts.CertFile, f.opts.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &keyPair
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cert, f.roots, f.modTimes = cert, roots, modTimes
+	return nil
+}
+
+// current reloads the files if one of them was modified, the previous certificates are kept if the new ones cannot be
+// loaded, e.g. while they are half written
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	if f.modified() {
+		if err := f.load(); err != nil {
+			f.logReloadError(err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cert, f.roots
+}
+
+// logReloadError logs the error unless it was already logged for the same modification times, the reload is retried on
+// every handshake until the files can be loaded
+func (f *tlsFiles) logReloadError(err error) {
+	modTimes, _ := f.statModTimes()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sameModTimes(modTimes, f.failedModTimes) {
+		return
+	}
+	f.failedModTimes = modTimes
+	f.logger.Error("Fail to reload the tls files, the previous certificates are kept, error=%v", err)
+}
+
+func sameModTimes(a, b map[string]time.Time) bool {
+	if a == nil || b == nil || len(a) != len(b) {
+		return false
+	}
+	for name, modTime := range a {
+		if other, ok := b[name]; !ok || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *tlsFiles) modified() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, modTime := range f.modTimes {
+		info, err := os.Stat(name)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+/*
+ *  Copyright 2018 Expedia Group.
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package haystack
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// testCA issues the certificates of the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(suite *TLSTestSuite, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	suite.Require().NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	suite.Require().NoError(err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the pem certificate and key of a server, for the dns name or ip address, or of a client
+func (ca *testCA) issue(suite *TLSTestSuite, name string, dnsName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(dnsName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	} else if dnsName != "" {
+		template.DNSNames = []string{dnsName}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	suite.Require().NoError(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	suite.Require().NoError(err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+type TLSTestSuite struct {
+	suite.Suite
+	dir      string
+	ca       *testCA
+	clients  *x509.CertPool
+	serverMu sync.Mutex
+	server   tls.Certificate
+	peers    []string
+}
+
+func (suite *TLSTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+	suite.ca = newTestCA(suite, "haystack-ca")
+	suite.clients = x509.NewCertPool()
+	suite.clients.AddCert(suite.ca.cert)
+	suite.setServerCertificate(suite.ca)
+	suite.peers = nil
+
+	suite.write("ca.pem", suite.ca.pem)
+	cert, key := suite.ca.issue(suite, "client-1", "")
+	suite.write("client.pem", cert)
+	suite.write("client-key.pem", key)
+}
+
+func (suite *TLSTestSuite) write(name string, data []byte) string {
+	path := filepath.Join(suite.dir, name)
+	suite.Require().NoError(ioutil.WriteFile(path, data, 0600))
+	// the modification time must change even within the resolution of the file system
+	modTime := time.Now().Add(time.Duration(len(suite.peers)+1) * time.Minute)
+	suite.Require().NoError(os.Chtimes(path, modTime, modTime))
+	return path
+}
+
+func (suite *TLSTestSuite) setServerCertificate(ca *testCA) {
+	suite.setServerCertificateFor(ca, "haystack-collector")
+}
+
+func (suite *TLSTestSuite) setServerCertificateFor(ca *testCA, dnsName string) {
+	certPEM, keyPEM := ca.issue(suite, "collector", dnsName)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	suite.Require().NoError(err)
+	suite.serverMu.Lock()
+	defer suite.serverMu.Unlock()
+	suite.server = cert
+}
+
+// serverTLSConfig requires a client certificate signed by the first ca and records its common name
+func (suite *TLSTestSuite) serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  suite.clients,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			suite.serverMu.Lock()
+			defer suite.serverMu.Unlock()
+			return &suite.server, nil
+		},
+		VerifyPeerCertificate: func(_ [][]byte, chains [][]*x509.Certificate) error {
+			suite.serverMu.Lock()
+			defer suite.serverMu.Unlock()
+			suite.peers = append(suite.peers, chains[0][0].Subject.CommonName)
+			return nil
+		},
+	}
+}
+
+func (suite *TLSTestSuite) options(reload bool) TLSOptions {
+	return TLSOptions{
+		CAFile:     filepath.Join(suite.dir, "ca.pem"),
+		CertFile:   filepath.Join(suite.dir, "client.pem"),
+		KeyFile:    filepath.Join(suite.dir, "client-key.pem"),
+		ServerName: "haystack-collector",
+		Reload:     reload,
+	}
+}
+
+func (suite *TLSTestSuite) startHTTPServer() *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	server.TLS = suite.serverTLSConfig()
+	server.StartTLS()
+	return server
+}
+
+func (suite *TLSTestSuite) newHTTPClient(url string, opts TLSOptions) *HTTPClient {
+	tlsConfig, err := NewTLSConfig(opts)
+	suite.Require().NoError(err)
+	client := NewHTTPClient(url, nil, 3*time.Second, ClientOptionsFactory.TLSConfig(tlsConfig))
+	client.SetLogger(NullLogger{})
+	return client
+}
+
+func (suite *TLSTestSuite) TestHTTPClientMutualTLS() {
+	server := suite.startHTTPServer()
+	defer server.Close()
+
+	client := suite.newHTTPClient(server.URL, suite.options(false))
+	suite.NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+	suite.Equal([]string{"client-1"}, suite.peers)
+
+	// the server certificate is for haystack-collector, not for the 127.0.0.1 of the url
+	opts := suite.options(false)
+	opts.ServerName = ""
+	suite.Error(suite.newHTTPClient(server.URL, opts).TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+
+	opts = suite.options(false)
+	opts.CertFile, opts.KeyFile = "", ""
+	suite.Error(suite.newHTTPClient(server.URL, opts).TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+}
+
+type tlsAgentServer struct {
+	spans chan *Span
+	peers chan string
+}
+
+func (s *tlsAgentServer) Dispatch(ctx context.Context, span *Span) (*DispatchResult, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			s.peers <- tlsInfo.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	s.spans <- span
+	return &DispatchResult{Code: DispatchResult_SUCCESS}, nil
+}
+
+// startAgentServer serves the agent over tls on a port of 127.0.0.1
+func (suite *TLSTestSuite) startAgentServer() (*grpc.Server, *tlsAgentServer, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(suite.serverTLSConfig())))
+	agent := &tlsAgentServer{spans: make(chan *Span, 1), peers: make(chan string, 1)}
+	RegisterSpanAgentServer(server, agent)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	return server, agent, listener.Addr().(*net.TCPAddr).Port
+}
+
+func (suite *TLSTestSuite) newGrpcClient(port int, opts TLSOptions) *GrpcClient {
+	tlsConfig, err := NewTLSConfig(opts)
+	suite.Require().NoError(err)
+	client := NewGrpcClient("127.0.0.1", port, 3*time.Second, ClientOptionsFactory.TLSConfig(tlsConfig))
+	client.SetLogger(NullLogger{})
+	return client
+}
+
+func (suite *TLSTestSuite) TestGrpcClientMutualTLS() {
+	server, agent, port := suite.startAgentServer()
+	defer server.Stop()
+
+	client := suite.newGrpcClient(port, suite.options(true))
+	defer func() {
+		suite.NoError(client.Close())
+	}()
+
+	suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+	suite.Equal("s1", (<-agent.spans).GetSpanId())
+	suite.Equal("client-1", <-agent.peers)
+}
+
+func (suite *TLSTestSuite) TestReloadingClientVerifiesTheDialHost() {
+	server, agent, port := suite.startAgentServer()
+	defer server.Stop()
+
+	// the server certificate is for haystack-collector, and the 127.0.0.1 host is not sent as the tls server name
+	opts := suite.options(true)
+	opts.ServerName = ""
+	client := suite.newGrpcClient(port, opts)
+	suite.Error(client.TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+	suite.NoError(client.Close())
+
+	suite.setServerCertificateFor(suite.ca, "127.0.0.1")
+	client = suite.newGrpcClient(port, opts)
+	suite.NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s2"}))
+	suite.Equal("s2", (<-agent.spans).GetSpanId())
+	suite.Equal("client-1", <-agent.peers)
+	suite.NoError(client.Close())
+
+	client = suite.newGrpcClient(port, suite.options(true))
+	suite.Error(client.TrySend(&Span{TraceId: "t1", SpanId: "s3"}), "the ServerName of the options is verified rather than the host")
+	suite.NoError(client.Close())
+
+	// used by another client, the config has no name to verify the server against
+	tlsConfig, err := NewTLSConfig(opts)
+	suite.Require().NoError(err)
+	err = tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{suite.ca.cert}})
+	suite.EqualError(err, "tls: no server name to verify the server certificate against")
+}
+
+func (suite *TLSTestSuite) TestCertificatesAreReloaded() {
+	server := suite.startHTTPServer()
+	defer server.Close()
+
+	client := suite.newHTTPClient(server.URL, suite.options(true))
+	suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+
+	// rotate the client certificate and the ca of the server certificate
+	rotatedCA := newTestCA(suite, "haystack-ca-2")
+	suite.setServerCertificate(rotatedCA)
+	suite.write("ca.pem", rotatedCA.pem)
+	cert, key := suite.ca.issue(suite, "client-2", "")
+	suite.write("client.pem", cert)
+	suite.write("client-key.pem", key)
+
+	client.client.CloseIdleConnections()
+	suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s2"}))
+	suite.Equal([]string{"client-1", "client-2"}, suite.peers)
+
+	// a half written file keeps the previous certificates
+	suite.write("client.pem", cert[:len(cert)/2])
+	client.client.CloseIdleConnections()
+	suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s3"}))
+	suite.Equal([]string{"client-1", "client-2", "client-2"}, suite.peers)
+}
+
+func (suite *TLSTestSuite) TestReloadErrorsAreLoggedOncePerModification() {
+	server := suite.startHTTPServer()
+	defer server.Close()
+
+	var logs bytes.Buffer
+	client := suite.newHTTPClient(server.URL, suite.options(true))
+	client.SetLogger(NewStdLogger(log.New(&logs, "", 0)))
+	suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s1"}))
+
+	cert, _ := suite.ca.issue(suite, "client-2", "")
+	path := suite.write("client.pem", cert[:len(cert)/2])
+	for i := 0; i < 2; i++ {
+		client.client.CloseIdleConnections()
+		suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s2"}))
+	}
+	suite.Equal(1, strings.Count(logs.String(), "Fail to reload the tls files"))
+
+	modTime := time.Now().Add(time.Hour)
+	suite.Require().NoError(os.Chtimes(path, modTime, modTime))
+	client.client.CloseIdleConnections()
+	suite.Require().NoError(client.TrySend(&Span{TraceId: "t1", SpanId: "s3"}))
+	suite.Equal(2, strings.Count(logs.String(), "Fail to reload the tls files"))
+	suite.Equal([]string{"client-1", "client-1", "client-1", "client-1"}, suite.peers)
+}
+
+func (suite *TLSTestSuite) TestInvalidOptions() {
+	opts := suite.options(false)
+	opts.KeyFile = ""
+	_, err := NewTLSConfig(opts)
+	suite.Error(err)
+
+	opts = suite.options(false)
+	opts.CAFile = filepath.Join(suite.dir, "missing.pem")
+	_, err = NewTLSConfig(opts)
+	suite.Error(err)
+
+	opts = suite.options(false)
+	opts.CAFile = suite.write("empty.pem", []byte("no certificate"))
+	_, err = NewTLSConfig(opts)
+	suite.EqualError(err, "no certificate found in tls ca file "+opts.CAFile)
+
+	config, err := NewTLSConfig(TLSOptions{})
+	suite.Require().NoError(err)
+	suite.Nil(config.RootCAs, "the system roots are used")
+}
+
+func TestUnitTLSSuite(t *testing.T) {
+	suite.Run(t, new(TLSTestSuite))
+}
@@ -19,23 +19,35 @@ package haystack
 
 import (
 	"io"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 )
 
 /*Tracer implements the opentracing.tracer*/
 type Tracer struct {
-	serviceName     string
-	logger          Logger
-	dispatcher      Dispatcher
-	commonTags      []opentracing.Tag
-	timeNow         func() time.Time
-	idGenerator     func() string
-	propagators     map[interface{}]Propagator
-	useDualSpanMode bool
+	// accessed atomically, kept first for 64-bit alignment
+	spansStarted  int64
+	spansFinished int64
+	spansDetached int64
+	drops         dropCounters
+
+	serviceName           string
+	logger                Logger
+	dispatcher            Dispatcher
+	commonTags            []opentracing.Tag
+	timeNow               func() time.Time
+	traceIDGenerator      IDGenerator
+	idGenerator           IDGenerator
+	propagators           map[interface{}]Propagator
+	useDualSpanMode       bool
+	multiValueTags        bool
+	referencePriority     ReferencePriority
+	disableBaggageLogging bool
+	limits                SpanLimits
+	baggageRestrictions   *BaggageRestrictions
 }
 
 /*NewTracer creates a new tracer*/
@@ -65,13 +77,10 @@ func NewTracer(
 	}
 
 	if tracer.idGenerator == nil {
-		tracer.idGenerator = func() string {
-			_uuid, err := uuid.NewUUID()
-			if err != nil {
-				panic(err)
-			}
-			return _uuid.String()
-		}
+		tracer.idGenerator = UUIDv1ID
+	}
+	if tracer.traceIDGenerator == nil {
+		tracer.traceIDGenerator = tracer.idGenerator
 	}
 
 	dispatcher.SetLogger(tracer.logger)
@@ -93,30 +102,19 @@ func (tracer *Tracer) StartSpan(
 		sso.StartTime = tracer.timeNow()
 	}
 
-	var followsFromIsParent = false
-	var parent *SpanContext
-
-	for _, ref := range sso.References {
-		if ref.Type == opentracing.ChildOfRef {
-			if parent == nil || followsFromIsParent {
-				parent = ref.ReferencedContext.(*SpanContext)
-			}
-		} else if ref.Type == opentracing.FollowsFromRef {
-			if parent == nil {
-				parent = ref.ReferencedContext.(*SpanContext)
-				followsFromIsParent = true
-			}
-		}
-	}
+	references := haystackReferences(sso.References)
+	parent := tracer.referencePriority.parentOf(references)
 
 	spanContext := tracer.createSpanContext(parent, tracer.isServerSpan(sso.Tags))
 
+	atomic.AddInt64(&tracer.spansStarted, 1)
 	span := &_Span{
 		tracer:        tracer,
 		context:       spanContext,
 		operationName: operationName,
 		startTime:     sso.StartTime,
 		duration:      0,
+		references:    references,
 	}
 
 	for _, tag := range tracer.Tags() {
@@ -125,13 +123,20 @@ func (tracer *Tracer) StartSpan(
 	for k, v := range sso.Tags {
 		span.SetTag(k, v)
 	}
+	// the references are exempt from the span limits, a truncated json array would not parse
+	if encoded := encodeReferences(references); encoded != "" {
+		span.putTag(ReferencesTagKey, encoded)
+	}
 
 	return span
 }
 
 func (tracer *Tracer) isServerSpan(spanTags map[string]interface{}) bool {
-	if spanKind, ok := spanTags[string(ext.SpanKind)]; ok && spanKind == "server" {
-		return true
+	switch spanKind := spanTags[string(ext.SpanKind)].(type) {
+	case string:
+		return spanKind == string(ext.SpanKindRPCServerEnum)
+	case ext.SpanKindEnum:
+		return spanKind == ext.SpanKindRPCServerEnum
 	}
 	return false
 }
@@ -139,7 +144,7 @@ func (tracer *Tracer) isServerSpan(spanTags map[string]interface{}) bool {
 func (tracer *Tracer) createSpanContext(parent *SpanContext, isServerSpan bool) *SpanContext {
 	if parent == nil || !parent.IsValid() {
 		return &SpanContext{
-			TraceID: tracer.idGenerator(),
+			TraceID: tracer.traceIDGenerator(),
 			SpanID:  tracer.idGenerator(),
 		}
 	}
@@ -177,7 +182,7 @@ func (tracer *Tracer) Inject(ctx opentracing.SpanContext, format interface{}, ca
 		return opentracing.ErrInvalidSpanContext
 	}
 	if injector, ok := tracer.propagators[format]; ok {
-		return injector.Inject(c, carrier)
+		return injector.Inject(tracer.restrictBaggage(c, "inject"), carrier)
 	}
 	return opentracing.ErrUnsupportedFormat
 }
@@ -188,7 +193,11 @@ func (tracer *Tracer) Extract(
 	carrier interface{},
 ) (opentracing.SpanContext, error) {
 	if extractor, ok := tracer.propagators[format]; ok {
-		return extractor.Extract(carrier)
+		c, err := extractor.Extract(carrier)
+		if err != nil {
+			return nil, err
+		}
+		return tracer.restrictBaggage(c, "extract"), nil
 	}
 	return nil, opentracing.ErrUnsupportedFormat
 }
@@ -198,8 +207,13 @@ func (tracer *Tracer) Tags() []opentracing.Tag {
 	return tracer.commonTags
 }
 
-/*DispatchSpan dispatches the span to a dispatcher*/
-func (tracer *Tracer) DispatchSpan(span *_Span) {
+/*DispatchSpan dispatches the span to a dispatcher, a span without a haystack span context is logged and skipped*/
+func (tracer *Tracer) DispatchSpan(span FinishedSpan) {
+	if _, ok := span.Context().(*SpanContext); !ok {
+		tracer.logger.Error("span %q has no haystack span context and is not dispatched", span.OperationName())
+		return
+	}
+	atomic.AddInt64(&tracer.spansFinished, 1)
 	if tracer.dispatcher != nil {
 		tracer.dispatcher.Dispatch(span)
 	}
@@ -57,3 +57,61 @@ func (t TracerOptions) UseDualSpanMode() TracerOption {
 		tracer.useDualSpanMode = true
 	}
 }
+
+/*IDGenerator sets the generator of both the trace and the span ids, defaults to UUIDv1ID*/
+func (t TracerOptions) IDGenerator(generator IDGenerator) TracerOption {
+	return func(tracer *Tracer) {
+		tracer.traceIDGenerator = generator
+		tracer.idGenerator = generator
+	}
+}
+
+/*IDGenerators sets distinct generators for the trace and the span ids, e.g. HexID128 and HexID64 for ids compatible with W3C trace context and B3*/
+func (t TracerOptions) IDGenerators(traceIDGenerator IDGenerator, spanIDGenerator IDGenerator) TracerOption {
+	return func(tracer *Tracer) {
+		tracer.traceIDGenerator = traceIDGenerator
+		tracer.idGenerator = spanIDGenerator
+	}
+}
+
+/*Clock sets the clock giving the start, finish and log times of the spans, defaults to the system clock. The span durations are measured on the monotonic clock only if the times it returns carry a monotonic reading, like the ones of time.Now*/
+func (t TracerOptions) Clock(clock Clock) TracerOption {
+	return func(tracer *Tracer) {
+		tracer.timeNow = clock.Now
+	}
+}
+
+/*SpanLimits bounds the number of tags and logs of the spans and the size of their values and baggage*/
+func (t TracerOptions) SpanLimits(limits SpanLimits) TracerOption {
+	return func(tracer *Tracer) {
+		tracer.limits = limits
+	}
+}
+
+/*MultiValueTags keeps every value set for a tag key instead of overwriting the previous one, as the tracer did before tag de-duplication*/
+func (t TracerOptions) MultiValueTags() TracerOption {
+	return func(tracer *Tracer) {
+		tracer.multiValueTags = true
+	}
+}
+
+/*BaggageRestrictions restricts the baggage items propagated by Inject and Extract, the rejected items are logged at debug level*/
+func (t TracerOptions) BaggageRestrictions(restrictions BaggageRestrictions) TracerOption {
+	return func(tracer *Tracer) {
+		tracer.baggageRestrictions = &restrictions
+	}
+}
+
+/*DisableBaggageLogging stops SetBaggageItem from logging a baggage event with the key and value of the item in the span*/
+func (t TracerOptions) DisableBaggageLogging() TracerOption {
+	return func(tracer *Tracer) {
+		tracer.disableBaggageLogging = true
+	}
+}
+
+/*ReferencePriority sets which reference becomes the parent of a span started with several ones, defaults to PreferChildOf*/
+func (t TracerOptions) ReferencePriority(priority ReferencePriority) TracerOption {
+	return func(tracer *Tracer) {
+		tracer.referencePriority = priority
+	}
+}
@@ -21,8 +21,11 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -71,22 +74,26 @@ func (suite *TracerTestSuite) TestTracerProperties() {
 	span1.Finish()
 
 	dispatcher := suite.dispatcher.(*InMemoryDispatcher)
-	suite.Len(dispatcher.spans, 2, "2 spans should be dispatched")
-	childSpan := dispatcher.spans[0]
-	childSpanContext := childSpan.Context().(*SpanContext)
-
-	parentSpan := dispatcher.spans[1]
-	parentSpanContext := parentSpan.Context().(*SpanContext)
-
-	suite.Equal(parentSpanContext.TraceID, childSpanContext.TraceID, "trace id should match for both parent and child span")
-	suite.Equal(parentSpanContext.SpanID, childSpanContext.ParentID, "parent id should match for parent and child span")
-	suite.NotEqual(parentSpanContext.SpanID, childSpanContext.SpanID, "span id should be unique for parent and child span")
-
-	for _, span := range dispatcher.spans {
-		suite.Len(span.Tags(), 2, "1 tag should be present on the span")
-		suite.Equal(span.Tags()[0], opentracing.Tag{Key: "t1", Value: "v1"}, "tag key should be t1")
-		suite.Equal(span.Tags()[1], tag, "tag key should be user_agent")
+	spans := dispatcher.Spans()
+	suite.Len(spans, 2, "2 spans should be dispatched")
+	childSpan := spans[0]
+	parentSpan := spans[1]
+
+	suite.Equal(parentSpan.GetTraceId(), childSpan.GetTraceId(), "trace id should match for both parent and child span")
+	suite.Equal(parentSpan.GetSpanId(), childSpan.GetParentSpanId(), "parent id should match for parent and child span")
+	suite.NotEqual(parentSpan.GetSpanId(), childSpan.GetSpanId(), "span id should be unique for parent and child span")
+
+	for _, span := range spans {
+		suite.Len(span.GetTags(), 2, "1 tag should be present on the span")
+		suite.assertTag(opentracing.Tag{Key: "t1", Value: "v1"}, span.GetTags()[0], "tag key should be t1")
+		suite.assertTag(tag, span.GetTags()[1], "tag key should be user_agent")
 	}
+
+	suite.Equal(spans, dispatcher.SpansByTraceID(parentSpan.GetTraceId()))
+	suite.Equal([]*Span{childSpan}, dispatcher.SpansByOperation("op2"))
+	suite.Equal([]*Span{childSpan}, dispatcher.ChildrenOf(parentSpan.GetSpanId()))
+	suite.Equal(spans, dispatcher.SpansWithTag("user_agent", "ua"))
+	suite.Empty(dispatcher.SpansWithTag("user_agent", "other"))
 }
 
 func (suite *TracerTestSuite) TestTracerWithDualSpanMode_1() {
@@ -108,27 +115,25 @@ func (suite *TracerTestSuite) TestTracerWithDualSpanMode_1() {
 	serverSpan.Finish()
 
 	dispatcher := suite.dispatcher.(*InMemoryDispatcher)
-	suite.Len(dispatcher.spans, 2, "2 spans should be dispatched")
-	receivedClientSpan := dispatcher.spans[0]
-	receivedClientSpanCtx := receivedClientSpan.Context().(*SpanContext)
-
-	receivedServerSpan := dispatcher.spans[1]
-	receivedServerSpanCtx := receivedServerSpan.Context().(*SpanContext)
-	suite.Equal(receivedServerSpanCtx.TraceID, "T1", "Trace Ids should match")
-	suite.Equal(receivedServerSpanCtx.Baggage[strings.ToLower("myKey")], "myVal", "baggage key should match")
-	suite.Equal(receivedServerSpanCtx.Baggage[strings.ToLower("mykey-1")], "myval", "baggage lowercase key should match")
-	suite.NotEqual(receivedServerSpanCtx.SpanID, "S1", "SpanId should be newly created")
-	suite.NotEqual(receivedServerSpanCtx.SpanID, "P1", "SpanId should be newly created")
-	suite.NotEqual(receivedServerSpanCtx.SpanID, "T1", "SpanId should be newly created")
-	suite.Equal(receivedServerSpanCtx.ParentID, "S1", "Parent Ids should match")
-	suite.Equal(receivedServerSpan.Tags()[1], serverTag, "span.kind tag should be present and equal to server")
-
-	suite.Equal(receivedClientSpanCtx.TraceID, "T1", "Parent Ids should match")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, receivedServerSpanCtx.SpanID, "SpanId should be newly created")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, receivedServerSpanCtx.SpanID, "SpanId should be newly created")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, receivedServerSpanCtx.SpanID, "SpanId should be newly created")
-	suite.Equal(receivedClientSpanCtx.ParentID, receivedServerSpanCtx.SpanID, "Parent Ids should match")
-	suite.Equal(receivedClientSpan.Tags()[1], clientTag, "span.kind tag should be present and equal to client")
+	spans := dispatcher.Spans()
+	suite.Len(spans, 2, "2 spans should be dispatched")
+	receivedClientSpan := spans[0]
+	receivedServerSpan := spans[1]
+	suite.Equal(receivedServerSpan.GetTraceId(), "T1", "Trace Ids should match")
+	suite.Equal(serverSpan.Context().(*SpanContext).Baggage[strings.ToLower("myKey")], "myVal", "baggage key should match")
+	suite.Equal(serverSpan.Context().(*SpanContext).Baggage[strings.ToLower("mykey-1")], "myval", "baggage lowercase key should match")
+	suite.NotEqual(receivedServerSpan.GetSpanId(), "S1", "SpanId should be newly created")
+	suite.NotEqual(receivedServerSpan.GetSpanId(), "P1", "SpanId should be newly created")
+	suite.NotEqual(receivedServerSpan.GetSpanId(), "T1", "SpanId should be newly created")
+	suite.Equal(receivedServerSpan.GetParentSpanId(), "S1", "Parent Ids should match")
+	suite.assertTag(serverTag, receivedServerSpan.GetTags()[1], "span.kind tag should be present and equal to server")
+
+	suite.Equal(receivedClientSpan.GetTraceId(), "T1", "Parent Ids should match")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), receivedServerSpan.GetSpanId(), "SpanId should be newly created")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), receivedServerSpan.GetSpanId(), "SpanId should be newly created")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), receivedServerSpan.GetSpanId(), "SpanId should be newly created")
+	suite.Equal(receivedClientSpan.GetParentSpanId(), receivedServerSpan.GetSpanId(), "Parent Ids should match")
+	suite.assertTag(clientTag, receivedClientSpan.GetTags()[1], "span.kind tag should be present and equal to client")
 }
 
 func (suite *TracerTestSuite) TestTracerWithSingleSpanMode_1() {
@@ -148,23 +153,21 @@ func (suite *TracerTestSuite) TestTracerWithSingleSpanMode_1() {
 	serverSpan.Finish()
 
 	dispatcher := suite.dispatcher.(*InMemoryDispatcher)
-	suite.Len(dispatcher.spans, 2, "2 spans should be dispatched")
-	receivedClientSpan := dispatcher.spans[0]
-	receivedClientSpanCtx := receivedClientSpan.Context().(*SpanContext)
-
-	receivedServerSpan := dispatcher.spans[1]
-	receivedServerSpanCtx := receivedServerSpan.Context().(*SpanContext)
-	suite.Equal(receivedServerSpanCtx.TraceID, "T1", "Trace Ids should match")
-	suite.Equal(receivedServerSpanCtx.SpanID, "S1", "Span Ids should match")
-	suite.Equal(receivedServerSpanCtx.ParentID, "P1", "Parent Ids should match")
-	suite.Equal(receivedServerSpan.Tags()[1], serverTag, "span.kind tag should be present and equal to server")
-
-	suite.Equal(receivedClientSpanCtx.TraceID, "T1", "Parent Ids should match")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, "S1", "SpanId should be newly created")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, "P1", "SpanId should be newly created")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, "T1", "SpanId should be newly created")
-	suite.Equal(receivedClientSpanCtx.ParentID, "S1", "Parent Ids should match")
-	suite.Equal(receivedClientSpan.Tags()[1], clientTag, "span.kind tag should be present and equal to client")
+	spans := dispatcher.Spans()
+	suite.Len(spans, 2, "2 spans should be dispatched")
+	receivedClientSpan := spans[0]
+	receivedServerSpan := spans[1]
+	suite.Equal(receivedServerSpan.GetTraceId(), "T1", "Trace Ids should match")
+	suite.Equal(receivedServerSpan.GetSpanId(), "S1", "Span Ids should match")
+	suite.Equal(receivedServerSpan.GetParentSpanId(), "P1", "Parent Ids should match")
+	suite.assertTag(serverTag, receivedServerSpan.GetTags()[1], "span.kind tag should be present and equal to server")
+
+	suite.Equal(receivedClientSpan.GetTraceId(), "T1", "Parent Ids should match")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), "S1", "SpanId should be newly created")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), "P1", "SpanId should be newly created")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), "T1", "SpanId should be newly created")
+	suite.Equal(receivedClientSpan.GetParentSpanId(), "S1", "Parent Ids should match")
+	suite.assertTag(clientTag, receivedClientSpan.GetTags()[1], "span.kind tag should be present and equal to client")
 }
 
 func (suite *TracerTestSuite) TestTracerWithSingleSpanMode_2() {
@@ -184,26 +187,35 @@ func (suite *TracerTestSuite) TestTracerWithSingleSpanMode_2() {
 	serverSpan.Finish()
 
 	dispatcher := suite.dispatcher.(*InMemoryDispatcher)
-	suite.Len(dispatcher.spans, 2, "2 spans should be dispatched")
-	receivedClientSpan := dispatcher.spans[0]
-	receivedClientSpanCtx := receivedClientSpan.Context().(*SpanContext)
-
-	receivedServerSpan := dispatcher.spans[1]
-	receivedServerSpanCtx := receivedServerSpan.Context().(*SpanContext)
+	spans := dispatcher.Spans()
+	suite.Len(spans, 2, "2 spans should be dispatched")
+	receivedClientSpan := spans[0]
+	receivedServerSpan := spans[1]
+
+	suite.Equal(receivedServerSpan.GetTraceId(), "T1", "Trace Ids should match")
+	suite.Equal(receivedServerSpan.GetSpanId(), "S1", "Span Ids should match")
+	suite.Equal(receivedServerSpan.GetParentSpanId(), "P1", "Parent Ids should match")
+	suite.Equal(receivedServerSpan.GetTags()[1].GetKey(), "error", "error tag should be present")
+	suite.Equal(receivedServerSpan.GetTags()[1].GetVBool(), true, "error tag should be true")
+
+	suite.Equal(receivedClientSpan.GetTraceId(), "T1", "Parent Ids should match")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), "S1", "SpanId should be newly created")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), "P1", "SpanId should be newly created")
+	suite.NotEqual(receivedClientSpan.GetSpanId(), "T1", "SpanId should be newly created")
+	suite.Equal(receivedClientSpan.GetParentSpanId(), "S1", "Parent Ids should match")
+	suite.Equal(receivedClientSpan.GetTags()[1].GetKey(), "error", "error tag should be present")
+	suite.Equal(receivedClientSpan.GetTags()[1].GetType(), Tag_BOOL, "error tag should be a bool")
+	suite.Equal(receivedClientSpan.GetTags()[1].GetVBool(), false, "error tag should be false")
+}
 
-	suite.Equal(receivedServerSpanCtx.TraceID, "T1", "Trace Ids should match")
-	suite.Equal(receivedServerSpanCtx.SpanID, "S1", "Span Ids should match")
-	suite.Equal(receivedServerSpanCtx.ParentID, "P1", "Parent Ids should match")
-	suite.Equal(receivedServerSpan.Tags()[1].Key, "error", "error tag should be present")
-	suite.Equal(receivedServerSpan.Tags()[1].Value, true, "error tag should be true")
+func (suite *TracerTestSuite) TestTracerWithSingleSpanMode_SpanKindEnum() {
+	clientSpan := suite.tracer.StartSpan("op1", ext.SpanKindRPCClient)
+	serverSpan := suite.tracer.StartSpan("op2", ext.SpanKindRPCServer, opentracing.ChildOf(clientSpan.Context()))
 
-	suite.Equal(receivedClientSpanCtx.TraceID, "T1", "Parent Ids should match")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, "S1", "SpanId should be newly created")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, "P1", "SpanId should be newly created")
-	suite.NotEqual(receivedClientSpanCtx.SpanID, "T1", "SpanId should be newly created")
-	suite.Equal(receivedClientSpanCtx.ParentID, "S1", "Parent Ids should match")
-	suite.Equal(receivedClientSpan.Tags()[1].Key, "error", "error tag should be present")
-	suite.Equal(receivedClientSpan.Tags()[1].Value, false, "error tag should be false")
+	clientSpanCtx := clientSpan.Context().(*SpanContext)
+	serverSpanCtx := serverSpan.Context().(*SpanContext)
+	suite.Equal(clientSpanCtx.SpanID, serverSpanCtx.SpanID, "server span should share the span id of its client span")
+	suite.Equal(clientSpanCtx.TraceID, serverSpanCtx.TraceID, "Trace Ids should match")
 }
 
 func (suite *TracerTestSuite) TestTracerInject() {
@@ -227,6 +239,29 @@ func (suite *TracerTestSuite) TestTracerInject() {
 	suite.Equal(true, ctx.(*SpanContext).IsExtractedContext)
 }
 
+func (suite *TracerTestSuite) TestWaitForSpans() {
+	dispatcher := suite.dispatcher.(*InMemoryDispatcher)
+
+	go func() {
+		suite.tracer.StartSpan("op1").Finish()
+		suite.tracer.StartSpan("op2").Finish()
+	}()
+	spans, err := dispatcher.WaitForSpans(2, 5*time.Second)
+	suite.NoError(err)
+	suite.Len(spans, 2)
+
+	spans, err = dispatcher.WaitForSpans(3, 10*time.Millisecond)
+	suite.Error(err, "wait should time out as only 2 spans are dispatched")
+	suite.Len(spans, 2)
+
+	dispatcher.DispatchProtoSpan(&Span{TraceId: "T1", SpanId: "S1", OperationName: "op3"})
+	suite.Len(dispatcher.SpansByTraceID("T1"), 1, "proto spans should be recorded too")
+}
+
+func (suite *TracerTestSuite) assertTag(expected opentracing.Tag, actual *Tag, msg string) {
+	suite.True(proto.Equal(ConvertToProtoTag(expected.Key, expected.Value), actual), "%s, got %v", msg, actual)
+}
+
 func buildHTTPHeaderCarrier(headerMap map[string]string) *opentracing.HTTPHeadersCarrier {
 	httpHeaderCarrier := opentracing.HTTPHeadersCarrier(make(map[string][]string))
 	for k, v := range headerMap {
@@ -235,6 +270,29 @@ func buildHTTPHeaderCarrier(headerMap map[string]string) *opentracing.HTTPHeader
 	return &httpHeaderCarrier
 }
 
+// foreignSpan is a finished span of another tracer
+type foreignSpan struct {
+	*SpanRecord
+}
+
+func (s foreignSpan) Context() opentracing.SpanContext {
+	return opentracing.NoopTracer{}.StartSpan("op").Context()
+}
+
+func (suite *TracerTestSuite) TestForeignSpansAreNotDispatched() {
+	span := foreignSpan{NewSpanRecord(&Span{OperationName: "foreign"})}
+	suite.NotPanics(func() {
+		suite.tracer.(*Tracer).DispatchSpan(span)
+	})
+	suite.Empty(suite.dispatcher.(*InMemoryDispatcher).Spans())
+	suite.Equal(int64(0), suite.tracer.(*Tracer).Stats().SpansFinished)
+
+	// the dispatchers called directly do not panic either
+	suite.NotPanics(func() {
+		NewSamplingDispatcher(suite.dispatcher, 0.5).Dispatch(span)
+	})
+}
+
 func TestUnitTracerSuite(t *testing.T) {
 	suite.Run(t, new(TracerTestSuite))
 }